@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/cloudscale-ch/cloudscale-go-sdk/v2"
+)
+
+// cloudscaleAccount is a cloudscale project the bucket metrics are fetched from. The facts of every account are
+// tagged with the account's zone, so every account must have a zone of its own.
+type cloudscaleAccount struct {
+	Name  string `json:"name"`
+	Token string `json:"token,omitempty"`
+	// TokenFile is a file containing the token, as alternative to Token. The file is read again when it changes.
+	TokenFile string `json:"token_file,omitempty"`
+	// Zone defaults to "cloudscale-<name>".
+	Zone string `json:"zone,omitempty"`
+}
+
+// account is a cloudscale account together with its client.
+type account struct {
+	cloudscaleAccount
+	client *cloudscale.Client
+}
+
+// loadAccounts returns the accounts from the accounts file, or the single account configured by CLOUDSCALE_API_TOKEN
+// (or CLOUDSCALE_API_TOKEN_FILE) with the zone "cloudscale" if there is no accounts file.
+func loadAccounts() ([]cloudscaleAccount, error) {
+	if file := os.Getenv(accountsEnvVariable); file != "" {
+		return loadAccountsFile(file)
+	}
+	a := cloudscaleAccount{
+		Name:      "default",
+		Token:     os.Getenv(tokenEnvVariable),
+		TokenFile: os.Getenv(tokenEnvVariable + secretFileSuffix),
+		Zone:      defaultSourceZone,
+	}
+	if a.Token == "" && a.TokenFile == "" {
+		return nil, fmt.Errorf("missing env var %q", tokenEnvVariable)
+	}
+	if a.Token != "" && a.TokenFile != "" {
+		return nil, fmt.Errorf("only one of env vars %q and %q can be set", tokenEnvVariable, tokenEnvVariable+secretFileSuffix)
+	}
+	return []cloudscaleAccount{a}, nil
+}
+
+// loadAccountsFile reads the accounts from a JSON file containing a list of accounts.
+func loadAccountsFile(file string) ([]cloudscaleAccount, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open accounts file: %w", err)
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	var accounts []cloudscaleAccount
+	if err := dec.Decode(&accounts); err != nil {
+		return nil, fmt.Errorf("cannot parse accounts file %s: %w", file, err)
+	}
+	accounts, err = validateAccounts(accounts)
+	if err != nil {
+		return nil, fmt.Errorf("accounts file %s: %w", file, err)
+	}
+	return accounts, nil
+}
+
+// validateAccounts sets the default zones and ensures that names and zones are unique.
+func validateAccounts(accounts []cloudscaleAccount) ([]cloudscaleAccount, error) {
+	if len(accounts) == 0 {
+		return nil, fmt.Errorf("no accounts configured")
+	}
+	names := map[string]bool{}
+	zones := map[string]string{}
+	for i := range accounts {
+		a := &accounts[i]
+		if a.Name == "" {
+			return nil, fmt.Errorf("account %d: name must be set", i)
+		}
+		if names[a.Name] {
+			return nil, fmt.Errorf("account %q is configured more than once", a.Name)
+		}
+		names[a.Name] = true
+		if (a.Token == "") == (a.TokenFile == "") {
+			return nil, fmt.Errorf("account %q: either token or token_file must be set", a.Name)
+		}
+		if a.Zone == "" {
+			a.Zone = defaultSourceZone + "-" + a.Name
+		}
+		if other, ok := zones[a.Zone]; ok {
+			return nil, fmt.Errorf("accounts %q and %q have the same zone %q", other, a.Name, a.Zone)
+		}
+		zones[a.Zone] = a.Name
+	}
+	return accounts, nil
+}
+
+// newAccounts creates a client for every account. The token of accounts with a token file is read on every request,
+// so rotated tokens are picked up.
+func newAccounts(accounts []cloudscaleAccount) []account {
+	clients := make([]account, 0, len(accounts))
+	for _, a := range accounts {
+		httpClient := http.DefaultClient
+		if a.TokenFile != "" {
+			httpClient = &http.Client{Transport: &tokenFileTransport{token: newSecretFile(a.TokenFile), base: http.DefaultTransport}}
+		}
+		client := cloudscale.NewClient(httpClient)
+		client.AuthToken = a.Token
+		clients = append(clients, account{cloudscaleAccount: a, client: client})
+	}
+	return clients
+}
+
+// accountZones returns the zones of the accounts, in the order of the accounts.
+func accountZones(accounts []cloudscaleAccount) []string {
+	zones := make([]string, len(accounts))
+	for i, a := range accounts {
+		zones[i] = a.Zone
+	}
+	return zones
+}
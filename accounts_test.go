@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateAccounts(t *testing.T) {
+	accounts, err := validateAccounts([]cloudscaleAccount{
+		{Name: "rma", Token: "a", Zone: "cloudscale"},
+		{Name: "lpg", TokenFile: "/secrets/lpg"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cloudscale", "cloudscale-lpg"}, accountZones(accounts))
+
+	for name, accounts := range map[string][]cloudscaleAccount{
+		"empty":          nil,
+		"no name":        {{Token: "a"}},
+		"no token":       {{Name: "a"}},
+		"both tokens":    {{Name: "a", Token: "a", TokenFile: "/secrets/a"}},
+		"duplicate name": {{Name: "a", Token: "a"}, {Name: "a", Token: "b", Zone: "other"}},
+		"duplicate zone": {{Name: "a", Token: "a", Zone: "cloudscale-b"}, {Name: "b", Token: "b"}},
+	} {
+		_, err := validateAccounts(accounts)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestNewCatalog(t *testing.T) {
+	c := newCatalog([]string{"cloudscale", "cloudscale-lpg"})
+	require.Len(t, c.queries, 6)
+	require.Len(t, c.products, 6)
+	assert.Equal(t, "Object Storage - Storage (cloudscale.ch)", c.query(sourceQueryStorage+":cloudscale").Description)
+	assert.Equal(t, "Object Storage - Storage (cloudscale.ch, cloudscale-lpg)", c.query(sourceQueryStorage+":cloudscale-lpg").Description)
+	assert.NoError(t, validateUnits(c))
+	assert.Len(t, newCatalog([]string{defaultSourceZone}).queries, 3, "other catalogs are not affected")
+}
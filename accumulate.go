@@ -2,21 +2,35 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math/big"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/cloudscale-ch/cloudscale-go-sdk/v2"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/tenantmapping"
 	cloudscalev1 "github.com/vshn/provider-cloudscale/apis/cloudscale/v1"
 	corev1 "k8s.io/api/core/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-const (
-	organizationLabel = "appuio.io/organization"
-	namespaceLabel    = "crossplane.io/claim-namespace"
+var (
+	// defaultTenantLabels are the labels of a namespace holding its tenant, as used by APPUiO.
+	defaultTenantLabels = []string{"appuio.io/organization"}
+	// defaultNamespaceLabels are the labels of a bucket holding the namespace it has been claimed from.
+	defaultNamespaceLabels = []string{"crossplane.io/claim-namespace"}
 )
 
+// labelConfig configures the label and annotation keys the tenant and namespace are read from. Every list is tried in
+// order, the first key found is used. Labels of a namespace are tried before its annotations.
+type labelConfig struct {
+	tenantLabels      []string
+	tenantAnnotations []string
+	namespaceLabels   []string
+}
+
 // AccumulateKey represents one data point ("fact") in the billing database.
 // The actual value for the data point is not present in this type, as this type is just a map key, and the corresponding value is stored as a map value.
 type AccumulateKey struct {
@@ -33,115 +47,301 @@ func (this AccumulateKey) String() string {
 }
 
 /*
-accumulateBucketMetrics gets all the bucket metrics from cloudscale and puts them into a map. The map key is the "AccumulateKey",
+accumulateBucketMetrics gets all the bucket metrics of all the accounts from cloudscale and puts them into a map. The map key is the "AccumulateKey",
 and the value is the raw value of the data returned by cloudscale (e.g. bytes, requests). In order to construct the
 correct AccumulateKey, this function needs to fetch the ObjectUsers's tags, because that's where the zone, tenant and
-namespace are stored.
+namespace are stored. The buckets of all the clusters are merged, see mergeBucketOwners. The zone is the zone of the
+account the bucket belongs to.
 This method is "accumulating" data because it collects data from possibly multiple ObjectsUsers under the same
 AccumulateKey. This is because the billing system can't handle multiple ObjectsUsers per namespace.
+With hourly granularity, every interval of the metrics is accumulated separately, see
+accumulateIntervalsForObjectsUser. It is an error if cloudscale returned intervals of the whole day, see wholeDayIntervals. Before accumulating, the metrics are checked for completeness (see checkCompleteness). If the day is not complete yet,
+a *pendingError is returned, unless the day is settled (see daySettled) and is written with a warning instead.
+Buckets and namespaces unknown to Kubernetes are looked up in the tenant mapping, if configured. Kubernetes takes
+precedence over the mapping.
+The accumulated values are reconciled with the usage of all buckets, see reconcile.
 */
-func accumulateBucketMetrics(ctx context.Context, date time.Time, cloudscaleClient *cloudscale.Client, k8sclient client.Client) (map[AccumulateKey]uint64, error) {
-	bucketMetricsRequest := cloudscale.BucketMetricsRequest{Start: date, End: date}
-	bucketMetrics, err := cloudscaleClient.Metrics.GetBucketMetrics(ctx, &bucketMetricsRequest)
-	if err != nil {
-		return nil, err
+func accumulateBucketMetrics(ctx context.Context, date time.Time, accounts []account, clusters []cluster, cfg *config) (map[AccumulateKey]uint64, []reconciliation, error) {
+	bucketMetrics := make([]*cloudscale.BucketMetrics, len(accounts))
+	var pending *pendingError
+	for i, a := range accounts {
+		metrics, err := fetchBucketMetrics(ctx, date, a.client, cfg.bucketCountTolerance)
+		var accountPending *pendingError
+		if errors.As(err, &accountPending) {
+			if daySettled(date, time.Now(), cfg.pendingMaxDays) {
+				fmt.Fprintf(os.Stderr, "WARNING: Account %s: writing incomplete day, cloudscale will not complete it anymore: %v\n", a.Name, accountPending)
+				bucketMetrics[i] = metrics
+				continue
+			}
+			// a day is only written if it is complete for all accounts
+			if pending == nil {
+				pending = &pendingError{Date: date}
+			}
+			for _, reason := range accountPending.Reasons {
+				if len(accounts) > 1 {
+					reason = fmt.Sprintf("account %s: %s", a.Name, reason)
+				}
+				pending.Reasons = append(pending.Reasons, reason)
+			}
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("account %s: %w", a.Name, err)
+		}
+		bucketMetrics[i] = metrics
 	}
-
-	nsTenants, err := fetchNamespaces(ctx, k8sclient)
-	if err != nil {
-		return nil, err
+	if pending != nil {
+		return nil, nil, pending
+	}
+	if cfg.hourly {
+		for i, a := range accounts {
+			if wholeDayIntervals(bucketMetrics[i], date) {
+				return nil, nil, fmt.Errorf("account %s: cloudscale returned intervals of the whole day %s, hourly facts cannot be written, set %s to %q",
+					a.Name, date.Format("2006-01-02"), granularityEnvVariable, granularityDaily)
+			}
+		}
 	}
 
-	buckets, err := fetchBuckets(ctx, k8sclient)
+	owners, namespaces, err := fetchBucketOwners(ctx, clusters, cfg.labels)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	accumulated := make(map[AccumulateKey]uint64)
 
-	for _, bucketMetricsData := range bucketMetrics.Data {
-		name := bucketMetricsData.Subject.BucketName
-		ns, ok := buckets[name]
-		if !ok {
-			fmt.Fprintf(os.Stderr, "WARNING: Cannot sync bucket, bucket resource %q not found\n", name)
-			continue
+	var reconciliations []reconciliation
+	for i, a := range accounts {
+		for _, bucketMetricsData := range bucketMetrics[i].Data {
+			name := bucketMetricsData.Subject.BucketName
+			ns, tenant, err := bucketTenant(name, owners, namespaces, cfg.tenantMapping)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "WARNING: Cannot sync bucket, %v\n", err)
+				continue
+			}
+
+			if cfg.hourly {
+				accumulateIntervalsForObjectsUser(accumulated, bucketMetricsData, date, a.Zone, tenant, ns)
+				continue
+			}
+			err = accumulateBucketMetricsForObjectsUser(accumulated, bucketMetricsData, date, a.Zone, tenant, ns)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "WARNING: Cannot sync bucket %s: %v\n", name, err)
+				continue
+			}
 		}
-		tenant, ok := nsTenants[ns]
+
+		reconciliations = append(reconciliations, reconcile(cfg.catalog, date, a.Zone, bucketMetrics[i], accumulated)...)
+	}
+
+	return accumulated, reconciliations, nil
+}
+
+/*
+bucketTenant returns the namespace and tenant of the bucket. Kubernetes takes precedence over the tenant mapping: the
+namespace is taken from the Bucket resource if there is one, and the tenant from the labels or annotations of the
+namespace if it has any, also for buckets mapped to a namespace by the tenant mapping. The namespace of a Bucket
+resource is looked up in the cluster of the resource. The namespace of a mapped bucket is looked up in the cluster of
+the mapping entry, or in the only cluster with a namespace of that name.
+*/
+func bucketTenant(bucket string, owners map[string]bucketOwner, namespaces clusterNamespaces, mapping *tenantmapping.Mapping) (string, string, error) {
+	owner, ok := owners[bucket]
+	if !ok {
+		owner.Namespace, owner.Cluster, ok = mapping.BucketNamespace(bucket)
 		if !ok {
-			fmt.Fprintf(os.Stderr, "WARNING: Cannot sync bucket, namespace %q not found in map\n", ns)
-			continue
+			return "", "", fmt.Errorf("bucket resource %q not found", bucket)
+		}
+		if owner.Cluster == "" {
+			clusters := namespaces.clustersOf(owner.Namespace)
+			if len(clusters) > 1 {
+				return "", "", fmt.Errorf("namespace %q of mapped bucket %q exists in clusters %s, the cluster must be set in the tenant mapping", owner.Namespace, bucket, strings.Join(clusters, ", "))
+			}
+			if len(clusters) == 1 {
+				owner.Cluster = clusters[0]
+			}
 		}
+		owner.Tenant = namespaces[owner.Cluster][owner.Namespace]
+	}
+	tenant := owner.Tenant
+	if tenant == "" {
+		tenant, ok = mapping.NamespaceTenant(owner.Namespace)
+	}
+	if !ok {
+		return "", "", fmt.Errorf("namespace %q not found in map", owner.Namespace)
+	}
+	return owner.Namespace, tenant, nil
+}
 
-		err = accumulateBucketMetricsForObjectsUser(accumulated, bucketMetricsData, tenant, ns)
+// fetchBucketMetrics fetches the bucket metrics of the day starting at date and checks them for completeness, see
+// checkCompleteness. If the day is incomplete, the metrics are returned together with the *pendingError. The length of
+// the intervals cannot be requested, see wholeDayIntervals.
+func fetchBucketMetrics(ctx context.Context, date time.Time, cloudscaleClient *cloudscale.Client, bucketCountTolerance float64) (*cloudscale.BucketMetrics, error) {
+	bucketMetricsRequest := cloudscale.BucketMetricsRequest{Start: date, End: date}
+	bucketMetrics, err := cloudscaleClient.Metrics.GetBucketMetrics(ctx, &bucketMetricsRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	var previousBucketMetrics *cloudscale.BucketMetrics
+	if bucketCountTolerance >= 0 {
+		previousDate := date.AddDate(0, 0, -1)
+		previousBucketMetricsRequest := cloudscale.BucketMetricsRequest{Start: previousDate, End: previousDate}
+		previousBucketMetrics, err = cloudscaleClient.Metrics.GetBucketMetrics(ctx, &previousBucketMetricsRequest)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "WARNING: Cannot sync bucket %s: %v\n", name, err)
-			continue
+			return nil, err
 		}
 	}
+	return bucketMetrics, checkCompleteness(date, bucketMetrics, previousBucketMetrics, bucketCountTolerance)
+}
 
-	return accumulated, nil
+/*
+wholeDayIntervals returns true if any interval of the metrics covers the whole day starting at date. The cloudscale
+API only takes days and decides on the length of the intervals itself, so hourly granularity depends on it returning
+intervals shorter than a day.
+*/
+func wholeDayIntervals(metrics *cloudscale.BucketMetrics, date time.Time) bool {
+	dayLength := date.AddDate(0, 0, 1).Sub(date)
+	for _, data := range metrics.Data {
+		for _, interval := range data.TimeSeries {
+			if interval.End.Sub(interval.Start) >= dayLength {
+				return true
+			}
+		}
+	}
+	return false
 }
 
-func fetchBuckets(ctx context.Context, k8sclient client.Client) (map[string]string, error) {
+func fetchBuckets(ctx context.Context, k8sclient client.Client, labels labelConfig) (map[string]string, error) {
 	buckets := &cloudscalev1.BucketList{}
-	if err := k8sclient.List(ctx, buckets, client.HasLabels{namespaceLabel}); err != nil {
+	if err := k8sclient.List(ctx, buckets); err != nil {
 		return nil, fmt.Errorf("bucket list: %w", err)
 	}
 
 	bucketNS := map[string]string{}
 	for _, b := range buckets.Items {
-		bucketNS[b.GetBucketName()] = b.Labels[namespaceLabel]
+		if ns, ok := firstValue(b.Labels, labels.namespaceLabels); ok {
+			bucketNS[b.GetBucketName()] = ns
+		}
 	}
 	return bucketNS, nil
 }
 
-func fetchNamespaces(ctx context.Context, k8sclient client.Client) (map[string]string, error) {
+// fetchNamespaces returns the tenants of all the namespaces of the cluster, see namespaceTenant. The tenant is empty if
+// the namespace has none.
+func fetchNamespaces(ctx context.Context, k8sclient client.Client, labels labelConfig) (map[string]string, error) {
 	namespaces := &corev1.NamespaceList{}
-	if err := k8sclient.List(ctx, namespaces, client.HasLabels{organizationLabel}); err != nil {
+	if err := k8sclient.List(ctx, namespaces); err != nil {
 		return nil, fmt.Errorf("namespace list: %w", err)
 	}
 
 	nsTenants := map[string]string{}
 	for _, ns := range namespaces.Items {
-		nsTenants[ns.Name] = ns.Labels[organizationLabel]
+		// namespaces without tenant are kept, so mapped buckets are only looked up in the clusters they exist in
+		tenant, _ := namespaceTenant(ns, labels)
+		nsTenants[ns.Name] = tenant
 	}
 	return nsTenants, nil
 }
 
-func accumulateBucketMetricsForObjectsUser(accumulated map[AccumulateKey]uint64, bucketMetricsData cloudscale.BucketMetricsData, tenant, namespace string) error {
-	if len(bucketMetricsData.TimeSeries) != 1 {
-		return fmt.Errorf("there must be exactly one metrics data point, found %d", len(bucketMetricsData.TimeSeries))
+// namespaceTenant returns the tenant of the namespace from the first of the tenant labels or annotations it has.
+func namespaceTenant(ns corev1.Namespace, labels labelConfig) (string, bool) {
+	if tenant, ok := firstValue(ns.Labels, labels.tenantLabels); ok {
+		return tenant, true
+	}
+	return firstValue(ns.Annotations, labels.tenantAnnotations)
+}
+
+// firstValue returns the non-empty value of the first of the keys found in m.
+func firstValue(m map[string]string, keys []string) (string, bool) {
+	for _, key := range keys {
+		if value := m[key]; value != "" {
+			return value, true
+		}
 	}
+	return "", false
+}
 
-	// For now all the buckets have the same zone. This may change in the future if Cloudscale decides to have different
-	// prices for different locations.
-	zone := sourceZones[0]
+// accumulateBucketMetricsForObjectsUser accumulates the intervals of the time series under the AccumulateKey of the day
+// starting at date. The storage of the intervals is weighted by their share of the day, see bucketStorage.
+func accumulateBucketMetricsForObjectsUser(accumulated map[AccumulateKey]uint64, bucketMetricsData cloudscale.BucketMetricsData, date time.Time, zone, tenant, namespace string) error {
+	if len(bucketMetricsData.TimeSeries) == 0 {
+		return fmt.Errorf("there are no metrics data points")
+	}
 
 	sourceStorage := AccumulateKey{
 		Query:     sourceQueryStorage,
 		Zone:      zone,
 		Tenant:    tenant,
 		Namespace: namespace,
-		Start:     bucketMetricsData.TimeSeries[0].Start,
+		Start:     date,
 	}
 	sourceTrafficOut := AccumulateKey{
 		Query:     sourceQueryTrafficOut,
 		Zone:      zone,
 		Tenant:    tenant,
 		Namespace: namespace,
-		Start:     bucketMetricsData.TimeSeries[0].Start,
+		Start:     date,
 	}
 	sourceRequests := AccumulateKey{
 		Query:     sourceQueryRequests,
 		Zone:      zone,
 		Tenant:    tenant,
 		Namespace: namespace,
-		Start:     bucketMetricsData.TimeSeries[0].Start,
+		Start:     date,
 	}
 
-	accumulated[sourceStorage] += uint64(bucketMetricsData.TimeSeries[0].Usage.StorageBytes)
-	accumulated[sourceTrafficOut] += uint64(bucketMetricsData.TimeSeries[0].Usage.SentBytes)
-	accumulated[sourceRequests] += uint64(bucketMetricsData.TimeSeries[0].Usage.Requests)
+	accumulated[sourceStorage] += bucketStorage(bucketMetricsData.TimeSeries, date.AddDate(0, 0, 1).Sub(date))
+	for _, interval := range bucketMetricsData.TimeSeries {
+		accumulated[sourceTrafficOut] += uint64(interval.Usage.SentBytes)
+		accumulated[sourceRequests] += uint64(interval.Usage.Requests)
+	}
 
 	return nil
 }
+
+// bucketStorage returns the storage of a day of the given length, the storage of all the intervals weighted by their
+// share of the day. It is only rounded down once, so a single interval of the whole day keeps its storage.
+func bucketStorage(timeSeries []cloudscale.BucketMetricsInterval, dayLength time.Duration) uint64 {
+	total := new(big.Int)
+	for _, interval := range timeSeries {
+		storage := new(big.Int).SetUint64(uint64(interval.Usage.StorageBytes))
+		total.Add(total, storage.Mul(storage, big.NewInt(int64(interval.End.Sub(interval.Start)))))
+	}
+	return total.Quo(total, big.NewInt(int64(dayLength))).Uint64()
+}
+
+/*
+accumulateIntervalsForObjectsUser accumulates every interval of the time series under its own AccumulateKey, starting at
+the start of the interval. The raw storage value is in bytes stored during the whole day (see sourceUnits), so the
+storage of an interval is weighted by the interval's share of the day starting at date, see intervalStorages. The
+intervals of a day thus add up to the value of the day, see bucketStorage.
+*/
+func accumulateIntervalsForObjectsUser(accumulated map[AccumulateKey]uint64, bucketMetricsData cloudscale.BucketMetricsData, date time.Time, zone, tenant, namespace string) {
+	storages := intervalStorages(bucketMetricsData.TimeSeries, date.AddDate(0, 0, 1).Sub(date))
+	for i, interval := range bucketMetricsData.TimeSeries {
+		key := AccumulateKey{Zone: zone, Tenant: tenant, Namespace: namespace, Start: interval.Start}
+
+		key.Query = sourceQueryStorage
+		accumulated[key] += storages[i]
+		key.Query = sourceQueryTrafficOut
+		accumulated[key] += uint64(interval.Usage.SentBytes)
+		key.Query = sourceQueryRequests
+		accumulated[key] += uint64(interval.Usage.Requests)
+	}
+}
+
+// intervalStorages returns the storage of every interval weighted by its share of a day of the given length. Every
+// interval gets the difference of the storage of the intervals up to and including it and of those before it, both
+// rounded down, so the remainders are not lost and the intervals add up to bucketStorage.
+func intervalStorages(timeSeries []cloudscale.BucketMetricsInterval, dayLength time.Duration) []uint64 {
+	storages := make([]uint64, len(timeSeries))
+	total := new(big.Int)
+	var previous uint64
+	for i, interval := range timeSeries {
+		storage := new(big.Int).SetUint64(uint64(interval.Usage.StorageBytes))
+		total.Add(total, storage.Mul(storage, big.NewInt(int64(interval.End.Sub(interval.Start)))))
+		rounded := new(big.Int).Quo(total, big.NewInt(int64(dayLength))).Uint64()
+		storages[i] = rounded - previous
+		previous = rounded
+	}
+	return storages
+}
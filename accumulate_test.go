@@ -1,13 +1,25 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
 	"time"
 
 	"github.com/cloudscale-ch/cloudscale-go-sdk/v2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/tenantmapping"
+	"github.com/vshn/provider-cloudscale/apis"
+	cloudscalev1 "github.com/vshn/provider-cloudscale/apis/cloudscale/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 // assertEqualfUint64 implements the functionality of assert.Equalf for uint64, because assert.Equalf cannot print uint64 correctly.
@@ -36,7 +48,7 @@ func TestAccumulateBucketMetricsForObjectsUser(t *testing.T) {
 	bucketMetricsInterval := []cloudscale.BucketMetricsInterval{
 		{
 			Start: date,
-			End:   date,
+			End:   date.AddDate(0, 0, 1),
 			Usage: cloudscale.BucketMetricsIntervalUsage{
 				Requests:     5,
 				StorageBytes: 1000000,
@@ -49,7 +61,7 @@ func TestAccumulateBucketMetricsForObjectsUser(t *testing.T) {
 	}
 
 	accumulated := make(map[AccumulateKey]uint64)
-	assert.NoError(t, accumulateBucketMetricsForObjectsUser(accumulated, bucketMetricsData, organization, namespace))
+	assert.NoError(t, accumulateBucketMetricsForObjectsUser(accumulated, bucketMetricsData, date, zone, organization, namespace))
 
 	require.Len(t, accumulated, 3, "incorrect amount of values 'accumulated'")
 
@@ -69,3 +81,235 @@ func TestAccumulateBucketMetricsForObjectsUser(t *testing.T) {
 	key.Query = "object-storage-traffic-out"
 	assertEqualfUint64(t, uint64(2000000), accumulated[key], "incorrect value in %s", key)
 }
+
+func TestAccumulateBucketMetricsForObjectsUserIntervals(t *testing.T) {
+	location, err := time.LoadLocation("Europe/Zurich")
+	require.NoError(t, err, "could not load location Europe/Zurich")
+	// 2022-10-30 has 25 hours in Europe/Zurich
+	date := time.Date(2022, 10, 30, 0, 0, 0, 0, location)
+	bucketMetricsData := cloudscale.BucketMetricsData{TimeSeries: []cloudscale.BucketMetricsInterval{
+		{Start: date, End: date.Add(5 * time.Hour), Usage: cloudscale.BucketMetricsIntervalUsage{Requests: 1, StorageBytes: 1000, SentBytes: 10}},
+		{Start: date.Add(5 * time.Hour), End: date.AddDate(0, 0, 1), Usage: cloudscale.BucketMetricsIntervalUsage{Requests: 2, StorageBytes: 2000, SentBytes: 20}},
+	}}
+
+	accumulated := make(map[AccumulateKey]uint64)
+	require.NoError(t, accumulateBucketMetricsForObjectsUser(accumulated, bucketMetricsData, date, "cloudscale", "inity", "ns"))
+	key := AccumulateKey{Zone: "cloudscale", Tenant: "inity", Namespace: "ns", Start: date}
+	key.Query = sourceQueryStorage
+	assertEqualfUint64(t, 1800, accumulated[key], "storage of the intervals is weighted by their share of the day")
+	key.Query = sourceQueryTrafficOut
+	assertEqualfUint64(t, 30, accumulated[key], "incorrect traffic")
+	key.Query = sourceQueryRequests
+	assertEqualfUint64(t, 3, accumulated[key], "incorrect requests")
+
+	assert.Error(t, accumulateBucketMetricsForObjectsUser(accumulated, cloudscale.BucketMetricsData{}, date, "cloudscale", "inity", "ns"))
+}
+
+func TestBucketTenant(t *testing.T) {
+	mapping, err := tenantmapping.New([]tenantmapping.Entry{
+		{Bucket: "terraform-state", Cluster: "a", Namespace: "labelled"},
+		{Bucket: "backup-*", Namespace: "backups"},
+		{Bucket: "shared-a", Cluster: "a", Namespace: "shared"},
+		{Bucket: "shared-unknown", Namespace: "shared"},
+		{Namespace: "backups", Tenant: "mapped"},
+		{Namespace: "labelled", Tenant: "mapped"},
+	})
+	require.NoError(t, err)
+	owners := map[string]bucketOwner{
+		"claimed":   {Cluster: "a", Namespace: "labelled", Tenant: "inity"},
+		"no-label":  {Cluster: "a", Namespace: "backups"},
+		"claimed-b": {Cluster: "b", Namespace: "shared", Tenant: "vshn"},
+		// the namespace has a tenant in cluster a only
+		"no-label-b": {Cluster: "b", Namespace: "labelled"},
+	}
+	namespaces := clusterNamespaces{
+		"a": {"labelled": "inity", "backups": "", "shared": "acme"},
+		"b": {"shared": "vshn", "labelled": ""},
+	}
+
+	for bucket, expected := range map[string][2]string{
+		"claimed":         {"labelled", "inity"},
+		"no-label":        {"backups", "mapped"},
+		"claimed-b":       {"shared", "vshn"},
+		"no-label-b":      {"labelled", "mapped"},
+		"terraform-state": {"labelled", "inity"},
+		"backup-1":        {"backups", "mapped"},
+		"shared-a":        {"shared", "acme"},
+	} {
+		ns, tenant, err := bucketTenant(bucket, owners, namespaces, mapping)
+		require.NoError(t, err, bucket)
+		assert.Equal(t, expected, [2]string{ns, tenant}, bucket)
+	}
+
+	_, _, err = bucketTenant("shared-unknown", owners, namespaces, mapping)
+	assert.EqualError(t, err, `namespace "shared" of mapped bucket "shared-unknown" exists in clusters a, b, the cluster must be set in the tenant mapping`)
+	_, _, err = bucketTenant("unknown", owners, namespaces, mapping)
+	assert.Error(t, err)
+	_, _, err = bucketTenant("terraform-state", owners, nil, nil)
+	assert.Error(t, err, "without mapping, the bucket has no Bucket resource")
+
+	ns, tenant, err := bucketTenant("backup-1", owners, clusterNamespaces{"a": {"backups": "inity"}}, mapping)
+	require.NoError(t, err)
+	assert.Equal(t, [2]string{"backups", "inity"}, [2]string{ns, tenant}, "the namespace of the only cluster having it is used")
+}
+
+func TestNamespaceTenant(t *testing.T) {
+	labels := labelConfig{
+		tenantLabels:      []string{"appuio.io/organization", "example.com/tenant"},
+		tenantAnnotations: []string{"example.com/billing-tenant"},
+	}
+	namespace := func(labels, annotations map[string]string) corev1.Namespace {
+		return corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Labels: labels, Annotations: annotations}}
+	}
+
+	tenant, ok := namespaceTenant(namespace(map[string]string{"appuio.io/organization": "inity", "example.com/tenant": "vshn"}, nil), labels)
+	assert.True(t, ok)
+	assert.Equal(t, "inity", tenant, "the first label wins")
+
+	tenant, ok = namespaceTenant(namespace(map[string]string{"appuio.io/organization": "", "example.com/tenant": "vshn"}, nil), labels)
+	assert.True(t, ok)
+	assert.Equal(t, "vshn", tenant, "empty labels are skipped")
+
+	tenant, ok = namespaceTenant(namespace(nil, map[string]string{"example.com/billing-tenant": "acme"}), labels)
+	assert.True(t, ok)
+	assert.Equal(t, "acme", tenant, "annotations are a fallback")
+
+	_, ok = namespaceTenant(namespace(map[string]string{"other": "x"}, nil), labels)
+	assert.False(t, ok)
+}
+
+func TestAccumulateIntervalsForObjectsUser(t *testing.T) {
+	location, err := time.LoadLocation("Europe/Zurich")
+	require.NoError(t, err, "could not load location Europe/Zurich")
+	// 2022-10-30 has 25 hours in Europe/Zurich
+	date := time.Date(2022, 10, 30, 0, 0, 0, 0, location)
+	nextDate := date.AddDate(0, 0, 1)
+
+	var timeSeries []cloudscale.BucketMetricsInterval
+	for start := date; start.Before(nextDate); start = start.Add(time.Hour) {
+		timeSeries = append(timeSeries, cloudscale.BucketMetricsInterval{
+			Start: start,
+			End:   start.Add(time.Hour),
+			Usage: cloudscale.BucketMetricsIntervalUsage{Requests: 2, StorageBytes: 2500000, SentBytes: 1000},
+		})
+	}
+
+	accumulated := make(map[AccumulateKey]uint64)
+	accumulateIntervalsForObjectsUser(accumulated, cloudscale.BucketMetricsData{TimeSeries: timeSeries}, date, "cloudscale", "inity", "testnamespace")
+	require.Len(t, accumulated, 3*25, "one value per query and hour")
+
+	key := AccumulateKey{Zone: "cloudscale", Tenant: "inity", Namespace: "testnamespace", Start: date.Add(3 * time.Hour)}
+	key.Query = sourceQueryRequests
+	assertEqualfUint64(t, 2, accumulated[key], "incorrect value in %s", key)
+	key.Query = sourceQueryTrafficOut
+	assertEqualfUint64(t, 1000, accumulated[key], "incorrect value in %s", key)
+	key.Query = sourceQueryStorage
+	assertEqualfUint64(t, 100000, accumulated[key], "storage of an hour is weighted by its share of the day")
+
+	var storage uint64
+	for k, v := range accumulated {
+		if k.Query == sourceQueryStorage {
+			storage += v
+		}
+	}
+	assertEqualfUint64(t, 2500000, storage, "the hours add up to the storage of the day")
+}
+
+func TestIntervalStorages(t *testing.T) {
+	date := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	var timeSeries []cloudscale.BucketMetricsInterval
+	for start := date; start.Before(date.AddDate(0, 0, 1)); start = start.Add(8 * time.Hour) {
+		timeSeries = append(timeSeries, cloudscale.BucketMetricsInterval{Start: start, End: start.Add(8 * time.Hour), Usage: cloudscale.BucketMetricsIntervalUsage{StorageBytes: 1000}})
+	}
+	assert.Equal(t, []uint64{333, 333, 334}, intervalStorages(timeSeries, 24*time.Hour), "the remainders are not lost")
+	assertEqualfUint64(t, 1000, bucketStorage(timeSeries, 24*time.Hour), "incorrect storage of the day")
+}
+
+func TestAccumulateBucketMetricsHourly(t *testing.T) {
+	location, err := time.LoadLocation("Europe/Zurich")
+	require.NoError(t, err, "could not load location Europe/Zurich")
+	date := time.Date(2022, 10, 30, 0, 0, 0, 0, location)
+
+	hourly := cloudscale.BucketMetricsData{Subject: cloudscale.BucketMetricsDataSubject{BucketName: "hourly-bucket"}}
+	for start := date; start.Before(date.AddDate(0, 0, 1)); start = start.Add(time.Hour) {
+		hourly.TimeSeries = append(hourly.TimeSeries, cloudscale.BucketMetricsInterval{
+			Start: start,
+			End:   start.Add(time.Hour),
+			Usage: cloudscale.BucketMetricsIntervalUsage{Requests: 2, StorageBytes: 2500000},
+		})
+	}
+	unknown := cloudscale.BucketMetricsData{
+		Subject: cloudscale.BucketMetricsDataSubject{BucketName: "unknown-bucket"},
+		TimeSeries: []cloudscale.BucketMetricsInterval{
+			{Start: date, End: date.Add(12 * time.Hour), Usage: cloudscale.BucketMetricsIntervalUsage{Requests: 4}},
+			{Start: date.Add(12 * time.Hour), End: date.AddDate(0, 0, 1), Usage: cloudscale.BucketMetricsIntervalUsage{Requests: 6}},
+		},
+	}
+	data := []cloudscale.BucketMetricsData{hourly, unknown}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "2022-10-30", r.URL.Query().Get("start"))
+		_ = json.NewEncoder(w).Encode(cloudscale.BucketMetrics{Start: date, End: date, Data: data})
+	}))
+	defer server.Close()
+	cloudscaleClient := cloudscale.NewClient(server.Client())
+	cloudscaleClient.BaseURL, err = url.Parse(server.URL + "/")
+	require.NoError(t, err)
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, apis.AddToScheme(scheme))
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "testnamespace", Labels: map[string]string{"appuio.io/organization": "inity"}}},
+		&cloudscalev1.Bucket{
+			ObjectMeta: metav1.ObjectMeta{Name: "hourly-bucket", Labels: map[string]string{"crossplane.io/claim-namespace": "testnamespace"}},
+		},
+	).Build()
+
+	accounts := []account{{cloudscaleAccount: cloudscaleAccount{Name: "main", Zone: "cloudscale"}, client: cloudscaleClient}}
+	clusters := []cluster{{name: "cluster", client: k8sClient}}
+	cfg := &config{
+		catalog:              newCatalog([]string{defaultSourceZone}),
+		bucketCountTolerance: -1,
+		hourly:               true,
+		labels:               labelConfig{tenantLabels: defaultTenantLabels, namespaceLabels: defaultNamespaceLabels},
+	}
+	accumulated, reconciliations, err := accumulateBucketMetrics(context.Background(), date, accounts, clusters, cfg)
+	require.NoError(t, err)
+	require.Len(t, accumulated, 3*25, "one value per query and hour, the unknown bucket is skipped")
+
+	// the times decoded from JSON have a different location, so the keys are compared with time.Time.Equal
+	lastHour := func(query string) uint64 {
+		for key, value := range accumulated {
+			if key.Query == query && key.Start.Equal(date.Add(24*time.Hour)) {
+				return value
+			}
+		}
+		return 0
+	}
+	assertEqualfUint64(t, 100000, lastHour(sourceQueryStorage), "storage of the last hour is weighted by its share of the day")
+	assertEqualfUint64(t, 2, lastHour(sourceQueryRequests), "incorrect requests of the last hour")
+
+	require.Len(t, reconciliations, 3)
+	assert.Equal(t, reconciliation{Date: date, Query: sourceQueryStorage, Zone: "cloudscale", Total: 2500000, Attributed: 2500000, Unit: "GBDay"}, reconciliations[0])
+	assert.Equal(t, reconciliation{Date: date, Query: sourceQueryRequests, Zone: "cloudscale", Total: 60, Attributed: 50, Unit: "KReq"}, reconciliations[2])
+
+	hourly.TimeSeries = []cloudscale.BucketMetricsInterval{{Start: date, End: date.AddDate(0, 0, 1), Usage: cloudscale.BucketMetricsIntervalUsage{StorageBytes: 2500000}}}
+	data = []cloudscale.BucketMetricsData{hourly}
+	_, _, err = accumulateBucketMetrics(context.Background(), date, accounts, clusters, cfg)
+	assert.EqualError(t, err, `account main: cloudscale returned intervals of the whole day 2022-10-30, hourly facts cannot be written, set GRANULARITY to "daily"`)
+}
+
+func TestWholeDayIntervals(t *testing.T) {
+	location, err := time.LoadLocation("Europe/Zurich")
+	require.NoError(t, err, "could not load location Europe/Zurich")
+	date := time.Date(2022, 10, 30, 0, 0, 0, 0, location)
+
+	metrics := &cloudscale.BucketMetrics{Data: []cloudscale.BucketMetricsData{{TimeSeries: []cloudscale.BucketMetricsInterval{
+		{Start: date, End: date.Add(12 * time.Hour)},
+		{Start: date.Add(12 * time.Hour), End: date.AddDate(0, 0, 1)},
+	}}}}
+	assert.False(t, wholeDayIntervals(metrics, date))
+
+	metrics.Data = append(metrics.Data, cloudscale.BucketMetricsData{TimeSeries: []cloudscale.BucketMetricsInterval{{Start: date, End: date.AddDate(0, 0, 1)}}})
+	assert.True(t, wholeDayIntervals(metrics, date), "the day has 25 hours")
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/anomaly"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/factsmodel"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/heldfactsmodel"
+)
+
+// defaultAnomalyBaselineDays is the number of days before the collected day whose facts form the baseline.
+const defaultAnomalyBaselineDays = 14
+
+// anomalyConfig configures the anomaly detection of the db output. A factor of 0 disables it.
+type anomalyConfig struct {
+	factor       float64
+	baselineDays int
+	holdBack     bool
+	// hourly compares facts only to the facts at the same hour of the previous days, see baselineKey
+	hourly bool
+}
+
+// usageAnomaly is a fact whose quantity deviates from its baseline.
+type usageAnomaly struct {
+	Source   AccumulateKey
+	Quantity float64
+	anomaly.Result
+	Held bool
+}
+
+func (a usageAnomaly) String() string {
+	return fmt.Sprintf("%s %s: quantity %g is %.2fx the baseline %g", a.Source.Start.Format("2006-01-02"), a.Source, a.Quantity, a.Ratio, a.Baseline)
+}
+
+func loadAnomalyConfig() (anomalyConfig, error) {
+	cfg := anomalyConfig{baselineDays: defaultAnomalyBaselineDays}
+	var err error
+	if factorStr := os.Getenv(anomalyFactorEnvVariable); factorStr != "" {
+		cfg.factor, err = strconv.ParseFloat(factorStr, 64)
+		if err != nil {
+			return cfg, fmt.Errorf("env var %q not a number: %w", anomalyFactorEnvVariable, err)
+		}
+		if cfg.factor != 0 && cfg.factor <= 1 {
+			return cfg, fmt.Errorf("env var %q must be larger than 1", anomalyFactorEnvVariable)
+		}
+	}
+	if daysStr := os.Getenv(anomalyBaselineDaysEnvVariable); daysStr != "" {
+		cfg.baselineDays, err = strconv.Atoi(daysStr)
+		if err != nil {
+			return cfg, fmt.Errorf("env var %q not an integer: %w", anomalyBaselineDaysEnvVariable, err)
+		}
+		if cfg.baselineDays < 1 {
+			return cfg, fmt.Errorf("env var %q must be at least 1", anomalyBaselineDaysEnvVariable)
+		}
+	}
+	if holdBackStr := os.Getenv(anomalyHoldBackEnvVariable); holdBackStr != "" {
+		cfg.holdBack, err = strconv.ParseBool(holdBackStr)
+		if err != nil {
+			return cfg, fmt.Errorf("env var %q not a boolean: %w", anomalyHoldBackEnvVariable, err)
+		}
+	}
+	return cfg, nil
+}
+
+// detector returns the detector for the config. At least half of the baseline days must have facts for a baseline. There
+// is one fact per day and baseline with both granularities, see baselineKey.
+func (c anomalyConfig) detector() anomaly.Detector {
+	return anomaly.Detector{Factor: c.factor, MinSamples: (c.baselineDays + 1) / 2}
+}
+
+// loadBaselines returns the quantities of the facts of the given number of days before date, by baselineKey.
+func loadBaselines(ctx context.Context, rdb *sqlx.DB, c *catalog, date time.Time, days int, hourly bool) (map[string][]float64, error) {
+	tx, err := rdb.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	history, err := factsmodel.GetHistory(ctx, tx, date.AddDate(0, 0, -days), date, c.queryNames())
+	if err != nil {
+		return nil, err
+	}
+	baselines := make(map[string][]float64)
+	for _, h := range history {
+		key := baselineKey(h.Source(), h.Timestamp.In(date.Location()), hourly)
+		baselines[key] = append(baselines[key], h.Quantity)
+	}
+	return baselines, nil
+}
+
+// baselineKey returns the key of the baseline of the fact of the source at timestamp. With hourly granularity, usage
+// varies over the day, so a fact is only compared to the facts of the same hour of the day in the time zone of the
+// timestamp.
+func baselineKey(source string, timestamp time.Time, hourly bool) string {
+	if !hourly {
+		return source
+	}
+	return fmt.Sprintf("%s@%02d", source, timestamp.Hour())
+}
+
+/*
+checkAnomaly compares the quantity of the fact to the median of the previous facts of the same source, see baselineKey.
+Anomalies are logged, exported as metric and recorded in the run. If holding back is enabled, anomalous facts are
+stored in the collector_held_facts table instead of being written, until they are approved with the held-facts
+command. The quantity is converted and rounded with the query and product resolved from the database, like the fact
+is written, see factQuantity. checkAnomaly returns true if the fact must not be written.
+*/
+func (s *dbSink) checkAnomaly(ctx context.Context, source AccumulateKey, value uint64, history []float64) (bool, error) {
+	tx, err := s.rdb.BeginTxx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	c, err := s.resolveSource(ctx, tx, source)
+	if err != nil {
+		return false, err
+	}
+	quantity, err := factQuantity(source, value, c, s.rounding)
+	if err != nil {
+		return false, err
+	}
+	result := s.anomaly.detector().Check(quantity, history)
+	if !result.Anomalous {
+		return false, nil
+	}
+
+	a := usageAnomaly{Source: source, Quantity: quantity, Result: result}
+	usageAnomalyRatioGauge.WithLabelValues(source.String()).Set(result.Ratio)
+	s.run.Anomalies++
+	defer func() {
+		s.run.anomalies = append(s.run.anomalies, a)
+	}()
+	if !s.anomaly.holdBack {
+		fmt.Fprintf(os.Stderr, "ANOMALY: %s\n", a)
+		return false, nil
+	}
+
+	existing, err := heldfactsmodel.GetBySource(ctx, tx, source.Query, source.Zone, source.Tenant, source.Namespace, source.Start)
+	if err != nil {
+		return false, err
+	}
+	if existing != nil && existing.Status == heldfactsmodel.StatusApproved {
+		fmt.Fprintf(os.Stderr, "ANOMALY: %s, approved by %s\n", a, existing.Id)
+		return false, nil
+	}
+	if existing != nil && existing.Status == heldfactsmodel.StatusRejected {
+		fmt.Fprintf(os.Stderr, "ANOMALY: %s, rejected by %s\n", a, existing.Id)
+		a.Held = true
+		s.run.FactsHeld++
+		return true, nil
+	}
+	held, err := heldfactsmodel.Hold(tx, &heldfactsmodel.HeldFact{
+		RunId:     s.run.Id,
+		Query:     source.Query,
+		Zone:      source.Zone,
+		Tenant:    source.Tenant,
+		Namespace: source.Namespace,
+		Timestamp: source.Start,
+		Value:     int64(value),
+		Quantity:  quantity,
+		Baseline:  result.Baseline,
+	})
+	if err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	fmt.Fprintf(os.Stderr, "ANOMALY: %s, held back as %s\n", a, held.Id)
+	a.Held = true
+	s.run.FactsHeld++
+	return true, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadAnomalyConfig(t *testing.T) {
+	cfg, err := loadAnomalyConfig()
+	require.NoError(t, err)
+	assert.Equal(t, anomalyConfig{baselineDays: defaultAnomalyBaselineDays}, cfg, "disabled by default")
+
+	t.Setenv(anomalyFactorEnvVariable, "10")
+	t.Setenv(anomalyBaselineDaysEnvVariable, "7")
+	t.Setenv(anomalyHoldBackEnvVariable, "true")
+	cfg, err = loadAnomalyConfig()
+	require.NoError(t, err)
+	assert.Equal(t, anomalyConfig{factor: 10, baselineDays: 7, holdBack: true}, cfg)
+	assert.Equal(t, 4, cfg.detector().MinSamples)
+
+	t.Setenv(anomalyFactorEnvVariable, "0.5")
+	_, err = loadAnomalyConfig()
+	assert.Error(t, err, "a factor below 1 flags everything")
+}
+
+func TestBaselineKey(t *testing.T) {
+	location, err := time.LoadLocation("Europe/Zurich")
+	require.NoError(t, err, "could not load location Europe/Zurich")
+	source := "object-storage-requests:cloudscale:inity:testnamespace"
+	summer := time.Date(2022, 10, 29, 13, 0, 0, 0, location)
+	winter := time.Date(2022, 10, 31, 13, 0, 0, 0, location)
+
+	assert.Equal(t, source, baselineKey(source, summer.Add(time.Hour), false), "daily facts have one baseline per source")
+	assert.Equal(t, source+"@13", baselineKey(source, summer, true))
+	assert.Equal(t, baselineKey(source, summer, true), baselineKey(source, winter, true), "the hour of the day is local")
+	assert.NotEqual(t, baselineKey(source, summer, true), baselineKey(source, summer.Add(time.Hour), true))
+}
+
+func TestFactQuantity(t *testing.T) {
+	source := AccumulateKey{Query: sourceQueryTrafficOut, Zone: "cloudscale", Tenant: "inity", Namespace: "ns"}
+	rounding, err := parseRoundingPolicies("half-up:3,object-storage-traffic-out:custom=up:0")
+	require.NoError(t, err)
+	query := &db.Query{Name: "object-storage-traffic-out:cloudscale", Unit: "GB"}
+
+	// the product resolved from the database, which is not part of the catalog, decides on the rounding
+	quantity, err := factQuantity(source, 1500000000, sourceCoverage{query: query, product: &db.Product{Source: "object-storage-traffic-out:custom"}, discount: &db.Discount{}}, rounding)
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, quantity)
+	quantity, err = factQuantity(source, 1500000000, sourceCoverage{query: query, product: &db.Product{Source: "object-storage-traffic-out:cloudscale"}, discount: &db.Discount{}}, rounding)
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, quantity)
+
+	_, err = factQuantity(source, 1, sourceCoverage{query: query}, rounding)
+	var coverage *coverageError
+	assert.ErrorAs(t, err, &coverage)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+	"github.com/jackc/pgtype"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/tokenmatcher"
+)
+
+// catalog holds the queries, products and discounts compiled into the collector for the zones of the cloudscale
+// accounts. Every cloudscale account has a zone of its own, see accountZones.
+type catalog struct {
+	zones     []string
+	queries   []*db.Query
+	products  []*db.Product
+	discounts []*db.Discount
+}
+
+// newCatalog returns the catalog of the given zones.
+func newCatalog(zones []string) *catalog {
+	return &catalog{
+		zones:     zones,
+		queries:   newEnsureQueries(zones),
+		products:  newEnsureProducts(zones),
+		discounts: ensureDiscounts,
+	}
+}
+
+// loadCatalog returns the catalog of the accounts from the accounts file, for the commands working on the collected
+// facts. Without accounts file, there is only the default zone.
+func loadCatalog() (*catalog, error) {
+	file := os.Getenv(accountsEnvVariable)
+	if file == "" {
+		return newCatalog([]string{defaultSourceZone}), nil
+	}
+	accounts, err := loadAccountsFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return newCatalog(accountZones(accounts)), nil
+}
+
+// The methods below resolve queries, products and discounts from the catalog instead of the database. They follow the
+// same rules as the corresponding functions of the *model packages.
+
+// query returns the query with the given name, or nil if there is none.
+func (c *catalog) query(name string) *db.Query {
+	for _, query := range c.queries {
+		if query.Name == name {
+			return query
+		}
+	}
+	return nil
+}
+
+// queryNames returns the names of all the queries.
+func (c *catalog) queryNames() []string {
+	names := make([]string, len(c.queries))
+	for i, query := range c.queries {
+		names[i] = query.Name
+	}
+	return names
+}
+
+// product returns the product best matching the given source at the given time, or nil if there is none.
+func (c *catalog) product(source string, timestamp time.Time) *db.Product {
+	var candidates []*db.Product
+	var sources []string
+	for _, product := range c.products {
+		if rangeContains(product.During, timestamp) {
+			candidates = append(candidates, product)
+			sources = append(sources, product.Source)
+		}
+	}
+	i := catalogBestMatch(source, sources)
+	if i < 0 {
+		return nil
+	}
+	return candidates[i]
+}
+
+// discount returns the discount best matching the given source at the given time, or nil if there is none.
+func (c *catalog) discount(source string, timestamp time.Time) *db.Discount {
+	var candidates []*db.Discount
+	var sources []string
+	for _, discount := range c.discounts {
+		if rangeContains(discount.During, timestamp) {
+			candidates = append(candidates, discount)
+			sources = append(sources, discount.Source)
+		}
+	}
+	i := catalogBestMatch(source, sources)
+	if i < 0 {
+		return nil
+	}
+	return candidates[i]
+}
+
+// catalogBestMatch returns the index of the candidate source best matching the given source, or -1 if none matches.
+func catalogBestMatch(source string, candidates []string) int {
+	candidatePatterns := make([]*tokenmatcher.TokenizedSource, len(candidates))
+	for i, candidate := range candidates {
+		candidatePatterns[i] = tokenmatcher.NewTokenizedSource(candidate)
+	}
+	match := tokenmatcher.FindBestMatch(tokenmatcher.NewTokenizedSource(source), candidatePatterns)
+	for i, candidate := range candidatePatterns {
+		if candidate == match {
+			return i
+		}
+	}
+	return -1
+}
+
+// rangeContains returns whether the time range contains the given timestamp, as the `@>` operator in PostgreSQL does.
+func rangeContains(r pgtype.Tstzrange, timestamp time.Time) bool {
+	if r.Status != pgtype.Present || r.LowerType == pgtype.Empty {
+		return false
+	}
+	if r.LowerType != pgtype.Unbounded && r.Lower.InfinityModifier != pgtype.NegativeInfinity {
+		if r.Lower.InfinityModifier == pgtype.Infinity || timestamp.Before(r.Lower.Time) {
+			return false
+		}
+		if r.LowerType == pgtype.Exclusive && timestamp.Equal(r.Lower.Time) {
+			return false
+		}
+	}
+	if r.UpperType != pgtype.Unbounded && r.Upper.InfinityModifier != pgtype.Infinity {
+		if r.Upper.InfinityModifier == pgtype.NegativeInfinity || timestamp.After(r.Upper.Time) {
+			return false
+		}
+		if r.UpperType == pgtype.Exclusive && timestamp.Equal(r.Upper.Time) {
+			return false
+		}
+	}
+	return true
+}
+
+// fallbackProduct returns the product of the catalog with the given source, to be used for sources without product,
+// see checkFallbackUnit.
+func (c *catalog) fallbackProduct(source string) (*db.Product, error) {
+	for _, product := range c.products {
+		if product.Source == source {
+			if err := checkFallbackUnit(product, c.queries); err != nil {
+				return nil, err
+			}
+			return product, nil
+		}
+	}
+	return nil, fmt.Errorf("fallback product %q not found in the catalog", source)
+}
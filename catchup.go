@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/factsmodel"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/queriesmodel"
+)
+
+/*
+findMissingDays checks the given number of days before date for facts written by this collector and returns all days
+for which there are none, oldest first. A day is considered missing if there is no fact of any of our queries during
+the day, at midnight or, with hourly granularity, at any hour of the day.
+*/
+func findMissingDays(ctx context.Context, rdb *sqlx.DB, c *catalog, date time.Time, days int) ([]time.Time, error) {
+	tx, err := rdb.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var queryIds []string
+	for _, ensureQuery := range c.queries {
+		query, err := queriesmodel.GetByName(ctx, tx, ensureQuery.Name)
+		if err != nil {
+			return nil, err
+		}
+		if query != nil {
+			queryIds = append(queryIds, query.Id)
+		}
+	}
+
+	return missingDays(date, days, func(from, to time.Time) (int, error) {
+		return factsmodel.CountByTimeRangeAndQueries(ctx, tx, from, to, queryIds)
+	})
+}
+
+// missingDays returns the days among the given number of days before date for which count returns no facts, oldest
+// first. count is called with the start of the day and the start of the next day, so it covers every hour of days with
+// 23 or 25 hours.
+func missingDays(date time.Time, days int, count func(from, to time.Time) (int, error)) ([]time.Time, error) {
+	var missing []time.Time
+	for i := days; i > 0; i-- {
+		day := date.AddDate(0, 0, -i)
+		n, err := count(day, day.AddDate(0, 0, 1))
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			missing = append(missing, day)
+		}
+	}
+	return missing, nil
+}
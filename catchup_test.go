@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMissingDays(t *testing.T) {
+	location, err := time.LoadLocation("Europe/Zurich")
+	require.NoError(t, err, "could not load location Europe/Zurich")
+	date := time.Date(2022, 11, 1, 0, 0, 0, 0, location)
+
+	// hourly facts, the last one of 2022-10-30 starts at 23:00 after the switch to winter time
+	facts := []time.Time{
+		time.Date(2022, 10, 28, 0, 0, 0, 0, location),
+		time.Date(2022, 10, 29, 13, 0, 0, 0, location),
+		time.Date(2022, 10, 30, 23, 0, 0, 0, location),
+	}
+	count := func(from, to time.Time) (int, error) {
+		n := 0
+		for _, fact := range facts {
+			if !fact.Before(from) && fact.Before(to) {
+				n++
+			}
+		}
+		return n, nil
+	}
+
+	missing, err := missingDays(date, 5, count)
+	require.NoError(t, err)
+	assert.Equal(t, []time.Time{
+		time.Date(2022, 10, 27, 0, 0, 0, 0, location),
+		time.Date(2022, 10, 31, 0, 0, 0, 0, location),
+	}, missing, "days with a fact at any hour are not missing")
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/vshn/cloudscale-metrics-collector/pkg/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// clusterConfig configures the connection to a Kubernetes cluster, either by a context of a kubeconfig or by a server
+// url and token.
+type clusterConfig struct {
+	Name       string `json:"name"`
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	Context    string `json:"context,omitempty"`
+	ServerURL  string `json:"server_url,omitempty"`
+	Token      string `json:"token,omitempty"`
+	// TokenFile is a file containing the token, as alternative to Token.
+	TokenFile string `json:"token_file,omitempty"`
+}
+
+// cluster is a Kubernetes cluster buckets are claimed from.
+type cluster struct {
+	name   string
+	client client.Client
+}
+
+// loadClusterConfigs reads the clusters from a JSON file containing a list of clusters.
+func loadClusterConfigs(file string) ([]clusterConfig, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open clusters file: %w", err)
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	var clusters []clusterConfig
+	if err := dec.Decode(&clusters); err != nil {
+		return nil, fmt.Errorf("cannot parse clusters file %s: %w", file, err)
+	}
+	if err := validateClusterConfigs(clusters); err != nil {
+		return nil, fmt.Errorf("clusters file %s: %w", file, err)
+	}
+	return clusters, nil
+}
+
+func validateClusterConfigs(clusters []clusterConfig) error {
+	if len(clusters) == 0 {
+		return fmt.Errorf("no clusters configured")
+	}
+	names := map[string]bool{}
+	for i, c := range clusters {
+		if c.Name == "" {
+			return fmt.Errorf("cluster %d: name must be set", i)
+		}
+		if names[c.Name] {
+			return fmt.Errorf("cluster %q is configured more than once", c.Name)
+		}
+		names[c.Name] = true
+		if c.Kubeconfig == "" && (c.ServerURL == "" || (c.Token == "") == (c.TokenFile == "")) {
+			return fmt.Errorf("cluster %q: either kubeconfig or server_url and token or token_file must be set", c.Name)
+		}
+		if c.Kubeconfig != "" && (c.ServerURL != "" || c.Token != "" || c.TokenFile != "") {
+			return fmt.Errorf("cluster %q: kubeconfig cannot be combined with server_url and token", c.Name)
+		}
+	}
+	return nil
+}
+
+// newClusters creates a client for every configured cluster. Without a clusters file, the single cluster configured by
+// KUBECONFIG or KUBERNETES_SERVER_URL and KUBERNETES_SERVER_TOKEN (or KUBERNETES_SERVER_TOKEN_FILE) is used.
+func newClusters(cfg *config) ([]cluster, error) {
+	if len(cfg.clusters) == 0 {
+		k8sclient, err := kubernetes.NewClient(cfg.kubeconfig, cfg.kubernetesServerURL, cfg.kubernetesServerToken, cfg.kubernetesTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("kubernetes client: %w", err)
+		}
+		return []cluster{{name: "default", client: k8sclient}}, nil
+	}
+
+	clusters := make([]cluster, 0, len(cfg.clusters))
+	for _, c := range cfg.clusters {
+		var k8sclient client.Client
+		var err error
+		if c.Kubeconfig != "" {
+			k8sclient, err = kubernetes.NewClientForContext(c.Kubeconfig, c.Context)
+		} else {
+			k8sclient, err = kubernetes.NewClient("", c.ServerURL, c.Token, c.TokenFile)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("kubernetes client for cluster %q: %w", c.Name, err)
+		}
+		clusters = append(clusters, cluster{name: c.Name, client: k8sclient})
+	}
+	return clusters, nil
+}
+
+// bucketOwner is the namespace a bucket has been claimed from and its tenant. Tenant is empty if the namespace has no
+// tenant label or annotation.
+type bucketOwner struct {
+	Cluster   string
+	Namespace string
+	Tenant    string
+}
+
+// clusterNamespaces maps the names of clusters to their namespaces and the tenants of those. The tenant is empty if the
+// namespace has no tenant label or annotation. Namespaces are scoped per cluster, namespaces of the same name in
+// different clusters are unrelated.
+type clusterNamespaces map[string]map[string]string
+
+// clustersOf returns the sorted names of the clusters with a namespace of the given name.
+func (c clusterNamespaces) clustersOf(namespace string) []string {
+	var clusters []string
+	for cluster, namespaces := range c {
+		if _, ok := namespaces[namespace]; ok {
+			clusters = append(clusters, cluster)
+		}
+	}
+	sort.Strings(clusters)
+	return clusters
+}
+
+// fetchBucketOwners returns the owners of the buckets of all the clusters, see mergeBucketOwners, and the namespaces of
+// all the clusters.
+func fetchBucketOwners(ctx context.Context, clusters []cluster, labels labelConfig) (map[string]bucketOwner, clusterNamespaces, error) {
+	perCluster := make([]map[string]bucketOwner, 0, len(clusters))
+	namespaces := make(clusterNamespaces, len(clusters))
+	for _, c := range clusters {
+		nsTenants, err := fetchNamespaces(ctx, c.client, labels)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cluster %s: %w", c.name, err)
+		}
+		buckets, err := fetchBuckets(ctx, c.client, labels)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cluster %s: %w", c.name, err)
+		}
+		owners := make(map[string]bucketOwner, len(buckets))
+		for bucket, ns := range buckets {
+			owners[bucket] = bucketOwner{Cluster: c.name, Namespace: ns, Tenant: nsTenants[ns]}
+		}
+		perCluster = append(perCluster, owners)
+		namespaces[c.name] = nsTenants
+	}
+	return mergeBucketOwners(perCluster), namespaces, nil
+}
+
+/*
+mergeBucketOwners merges the bucket owners of several clusters. A bucket found in more than one cluster is only kept if
+it has the same namespace and tenant in all of them, and a warning is logged as bucket names should be unique.
+Otherwise it is a conflict: a warning is logged and the bucket is dropped, so it is neither billed to the wrong tenant
+nor twice.
+*/
+func mergeBucketOwners(perCluster []map[string]bucketOwner) map[string]bucketOwner {
+	merged := map[string]bucketOwner{}
+	clustersOf := map[string][]string{}
+	conflicts := map[string]bool{}
+	for _, owners := range perCluster {
+		for bucket, owner := range owners {
+			clustersOf[bucket] = append(clustersOf[bucket], owner.Cluster)
+			if conflicts[bucket] {
+				continue
+			}
+			existing, ok := merged[bucket]
+			if !ok {
+				merged[bucket] = owner
+				continue
+			}
+			if existing.Namespace != owner.Namespace || existing.Tenant != owner.Tenant {
+				conflicts[bucket] = true
+				delete(merged, bucket)
+			}
+		}
+	}
+
+	buckets := make([]string, 0, len(clustersOf))
+	for bucket, clusters := range clustersOf {
+		if len(clusters) > 1 {
+			buckets = append(buckets, bucket)
+		}
+	}
+	sort.Strings(buckets)
+	for _, bucket := range buckets {
+		clusters := strings.Join(clustersOf[bucket], ", ")
+		if conflicts[bucket] {
+			fmt.Fprintf(os.Stderr, "WARNING: Cannot sync bucket, bucket %q is claimed in clusters %s with different namespaces or tenants\n", bucket, clusters)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "WARNING: Bucket %q is claimed in clusters %s with the same namespace and tenant, it is only billed once\n", bucket, clusters)
+	}
+	return merged
+}
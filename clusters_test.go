@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vshn/provider-cloudscale/apis"
+	cloudscalev1 "github.com/vshn/provider-cloudscale/apis/cloudscale/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestValidateClusterConfigs(t *testing.T) {
+	assert.NoError(t, validateClusterConfigs([]clusterConfig{
+		{Name: "a", Kubeconfig: "/kubeconfig", Context: "a"},
+		{Name: "b", ServerURL: "https://b:6443", Token: "token"},
+		{Name: "c", ServerURL: "https://c:6443", TokenFile: "/secrets/c"},
+	}))
+
+	for name, clusters := range map[string][]clusterConfig{
+		"empty":          nil,
+		"no name":        {{Kubeconfig: "/kubeconfig"}},
+		"duplicate":      {{Name: "a", Kubeconfig: "/kubeconfig"}, {Name: "a", Kubeconfig: "/kubeconfig"}},
+		"no token":       {{Name: "a", ServerURL: "https://a:6443"}},
+		"both tokens":    {{Name: "a", ServerURL: "https://a:6443", Token: "token", TokenFile: "/secrets/a"}},
+		"both":           {{Name: "a", Kubeconfig: "/kubeconfig", ServerURL: "https://a:6443", Token: "token"}},
+		"nothing at all": {{Name: "a"}},
+	} {
+		assert.Error(t, validateClusterConfigs(clusters), name)
+	}
+}
+
+func TestMergeBucketOwners(t *testing.T) {
+	merged := mergeBucketOwners([]map[string]bucketOwner{
+		{
+			"only-a":    {Cluster: "a", Namespace: "ns-a", Tenant: "inity"},
+			"same":      {Cluster: "a", Namespace: "ns", Tenant: "inity"},
+			"conflict":  {Cluster: "a", Namespace: "ns", Tenant: "inity"},
+			"conflict3": {Cluster: "a", Namespace: "ns", Tenant: "inity"},
+		},
+		{
+			"only-b":    {Cluster: "b", Namespace: "ns-b", Tenant: "vshn"},
+			"same":      {Cluster: "b", Namespace: "ns", Tenant: "inity"},
+			"conflict":  {Cluster: "b", Namespace: "ns", Tenant: "vshn"},
+			"conflict3": {Cluster: "b", Namespace: "other", Tenant: "inity"},
+		},
+		{
+			"conflict3": {Cluster: "c", Namespace: "ns", Tenant: "inity"},
+		},
+	})
+
+	assert.Len(t, merged, 3)
+	assert.Equal(t, "ns-a", merged["only-a"].Namespace)
+	assert.Equal(t, "vshn", merged["only-b"].Tenant)
+	assert.Equal(t, "a", merged["same"].Cluster, "the first cluster is kept")
+	assert.NotContains(t, merged, "conflict")
+	assert.NotContains(t, merged, "conflict3", "a conflicting bucket is not added again")
+}
+
+func TestClustersOf(t *testing.T) {
+	namespaces := clusterNamespaces{
+		"b": {"shared": "vshn", "only-b": ""},
+		"a": {"shared": "inity"},
+	}
+	assert.Equal(t, []string{"a", "b"}, namespaces.clustersOf("shared"))
+	assert.Equal(t, []string{"b"}, namespaces.clustersOf("only-b"), "namespaces without tenant exist as well")
+	assert.Empty(t, namespaces.clustersOf("unknown"))
+}
+
+func TestFetchBucketOwners(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, apis.AddToScheme(scheme))
+	namespace := func(name, tenant string) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"appuio.io/organization": tenant}}}
+	}
+	bucket := func(name, ns string) *cloudscalev1.Bucket {
+		return &cloudscalev1.Bucket{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"crossplane.io/claim-namespace": ns}}}
+	}
+	// the namespace foo exists in both clusters with different tenants
+	clusters := []cluster{
+		{name: "a", client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(namespace("foo", "inity"), bucket("bucket-a", "foo")).Build()},
+		{name: "b", client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(namespace("foo", "vshn"), &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "unlabelled"}}).Build()},
+	}
+
+	owners, namespaces, err := fetchBucketOwners(context.Background(), clusters, labelConfig{tenantLabels: defaultTenantLabels, namespaceLabels: defaultNamespaceLabels})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bucketOwner{"bucket-a": {Cluster: "a", Namespace: "foo", Tenant: "inity"}}, owners)
+	assert.Equal(t, clusterNamespaces{"a": {"foo": "inity"}, "b": {"foo": "vshn", "unlabelled": ""}}, namespaces)
+
+	ns, tenant, err := bucketTenant("bucket-a", owners, namespaces, nil)
+	require.NoError(t, err)
+	assert.Equal(t, [2]string{"foo", "inity"}, [2]string{ns, tenant}, "the bucket is billed to the tenant of its own cluster")
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cloudscale-ch/cloudscale-go-sdk/v2"
+)
+
+// pendingError is returned if the metrics of a day do not look complete yet. The day must not be written to the
+// database and has to be collected again in a later run.
+type pendingError struct {
+	Date    time.Time
+	Reasons []string
+}
+
+func (e *pendingError) Error() string {
+	return fmt.Sprintf("day %s is pending: %s", e.Date.Format("2006-01-02"), strings.Join(e.Reasons, "; "))
+}
+
+/*
+checkCompleteness verifies that the bucket metrics returned by cloudscale for the day starting at date are complete.
+The cloudscale API may return incomplete data around midnight, so every time series of every bucket must cover the
+whole day without gaps, and the number of buckets must not drop by more than the given tolerance (a fraction, e.g. 0.1
+for 10%) compared to the previous day. A negative tolerance disables the bucket count check.
+If the data is incomplete, a *pendingError listing all the reasons is returned.
+*/
+func checkCompleteness(date time.Time, current, previous *cloudscale.BucketMetrics, tolerance float64) error {
+	dayStart := date
+	dayEnd := date.AddDate(0, 0, 1)
+
+	var reasons []string
+	for _, bucketMetricsData := range current.Data {
+		if err := checkTimeSeriesCoversDay(bucketMetricsData.TimeSeries, dayStart, dayEnd); err != nil {
+			reasons = append(reasons, fmt.Sprintf("bucket %q: %v", bucketMetricsData.Subject.BucketName, err))
+		}
+	}
+
+	if tolerance >= 0 && previous != nil && len(previous.Data) > 0 {
+		minimum := float64(len(previous.Data)) * (1 - tolerance)
+		if float64(len(current.Data)) < minimum {
+			reasons = append(reasons, fmt.Sprintf("found %d buckets, previous day had %d (tolerance %g)", len(current.Data), len(previous.Data), tolerance))
+		}
+	}
+
+	if len(reasons) > 0 {
+		return &pendingError{Date: date, Reasons: reasons}
+	}
+	return nil
+}
+
+// daySettled returns true if the day starting at date ended more than maxDays days before now. The metrics of a settled
+// day are final, an incomplete day won't be completed by cloudscale anymore, e.g. because buckets have been deleted.
+func daySettled(date, now time.Time, maxDays int) bool {
+	return !now.Before(date.AddDate(0, 0, 1+maxDays))
+}
+
+// checkTimeSeriesCoversDay ensures that the intervals are contiguous and span exactly from dayStart to dayEnd.
+func checkTimeSeriesCoversDay(timeSeries []cloudscale.BucketMetricsInterval, dayStart, dayEnd time.Time) error {
+	if len(timeSeries) == 0 {
+		return fmt.Errorf("no data points")
+	}
+	next := dayStart
+	for _, interval := range timeSeries {
+		if !interval.Start.Equal(next) {
+			return fmt.Errorf("interval starts at %s, expected %s", interval.Start.Format(time.RFC3339), next.Format(time.RFC3339))
+		}
+		if !interval.End.After(interval.Start) {
+			return fmt.Errorf("interval ending at %s is empty", interval.End.Format(time.RFC3339))
+		}
+		next = interval.End
+	}
+	if !next.Equal(dayEnd) {
+		return fmt.Errorf("data ends at %s, expected %s", next.Format(time.RFC3339), dayEnd.Format(time.RFC3339))
+	}
+	return nil
+}
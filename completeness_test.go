@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/cloudscale-ch/cloudscale-go-sdk/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func bucketMetricsWithIntervals(count int, intervals ...cloudscale.BucketMetricsInterval) *cloudscale.BucketMetrics {
+	bucketMetrics := &cloudscale.BucketMetrics{}
+	for i := 0; i < count; i++ {
+		bucketMetrics.Data = append(bucketMetrics.Data, cloudscale.BucketMetricsData{
+			Subject:    cloudscale.BucketMetricsDataSubject{BucketName: "bucket"},
+			TimeSeries: intervals,
+		})
+	}
+	return bucketMetrics
+}
+
+func TestCheckCompleteness(t *testing.T) {
+	location, err := time.LoadLocation("Europe/Zurich")
+	require.NoError(t, err, "could not load location Europe/Zurich")
+
+	// 2022-10-30 has 25 hours in Europe/Zurich
+	date := time.Date(2022, 10, 30, 0, 0, 0, 0, location)
+	nextDate := time.Date(2022, 10, 31, 0, 0, 0, 0, location)
+	fullDay := cloudscale.BucketMetricsInterval{Start: date, End: nextDate}
+
+	assert.NoError(t, checkCompleteness(date, bucketMetricsWithIntervals(10, fullDay), bucketMetricsWithIntervals(10, fullDay), 0.1))
+	assert.NoError(t, checkCompleteness(date, bucketMetricsWithIntervals(9, fullDay), bucketMetricsWithIntervals(10, fullDay), 0.1))
+	assert.NoError(t, checkCompleteness(date, bucketMetricsWithIntervals(1, fullDay), bucketMetricsWithIntervals(10, fullDay), -1))
+	assert.NoError(t, checkCompleteness(date, bucketMetricsWithIntervals(1, fullDay), nil, 0.1))
+	assert.NoError(t, checkCompleteness(date, bucketMetricsWithIntervals(2,
+		cloudscale.BucketMetricsInterval{Start: date, End: date.Add(12 * time.Hour)},
+		cloudscale.BucketMetricsInterval{Start: date.Add(12 * time.Hour), End: nextDate},
+	), nil, 0.1))
+
+	var pending *pendingError
+
+	err = checkCompleteness(date, bucketMetricsWithIntervals(8, fullDay), bucketMetricsWithIntervals(10, fullDay), 0.1)
+	require.ErrorAs(t, err, &pending)
+	assert.Len(t, pending.Reasons, 1)
+
+	err = checkCompleteness(date, bucketMetricsWithIntervals(1, cloudscale.BucketMetricsInterval{Start: date, End: date.Add(20 * time.Hour)}), nil, 0.1)
+	require.ErrorAs(t, err, &pending)
+	assert.Len(t, pending.Reasons, 1)
+
+	err = checkCompleteness(date, bucketMetricsWithIntervals(1, cloudscale.BucketMetricsInterval{Start: date.Add(time.Hour), End: nextDate}), nil, 0.1)
+	require.ErrorAs(t, err, &pending)
+
+	err = checkCompleteness(date, bucketMetricsWithIntervals(2), nil, 0.1)
+	require.ErrorAs(t, err, &pending)
+	assert.Len(t, pending.Reasons, 2)
+}
+
+func TestDaySettled(t *testing.T) {
+	date := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	assert.False(t, daySettled(date, date.AddDate(0, 0, 1), 3))
+	assert.False(t, daySettled(date, date.AddDate(0, 0, 4).Add(-time.Second), 3))
+	assert.True(t, daySettled(date, date.AddDate(0, 0, 4), 3))
+	assert.True(t, daySettled(date, date.AddDate(0, 0, 1), 0))
+}
+
+func TestCollectDaysPending(t *testing.T) {
+	date := time.Now().Truncate(24 * time.Hour)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a bucket without data points is incomplete
+		_ = json.NewEncoder(w).Encode(bucketMetricsWithIntervals(1))
+	}))
+	defer server.Close()
+	cloudscaleClient := cloudscale.NewClient(server.Client())
+	var err error
+	cloudscaleClient.BaseURL, err = url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	accounts := []account{{cloudscaleAccount: cloudscaleAccount{Name: "main", Zone: "cloudscale"}, client: cloudscaleClient}}
+
+	pendingDays, err := collectDays(context.Background(), nil, date, accounts, nil, &config{catalog: newCatalog([]string{defaultSourceZone}), bucketCountTolerance: -1, pendingMaxDays: 3})
+	assert.Equal(t, []time.Time{date}, pendingDays)
+	assert.EqualError(t, err, "1 pending days will not be collected again without CATCHUP_DAYS, rerun the collector for them")
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+	"github.com/jmoiron/sqlx"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/discountsmodel"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/productsmodel"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/queriesmodel"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/units"
+)
+
+// sourceCoverage is the query, product and discount a fact of a source is written with. Any of them is nil if none
+// is found.
+type sourceCoverage struct {
+	query    *db.Query
+	product  *db.Product
+	discount *db.Discount
+}
+
+// missing returns the names of what has not been found.
+func (c sourceCoverage) missing() []string {
+	var missing []string
+	if c.query == nil {
+		missing = append(missing, "query")
+	}
+	if c.product == nil {
+		missing = append(missing, "product")
+	}
+	if c.discount == nil {
+		missing = append(missing, "discount")
+	}
+	return missing
+}
+
+// uncoveredSource is a source without query, product or discount.
+type uncoveredSource struct {
+	Source  string
+	Missing []string
+}
+
+// coverageError lists all the sources which cannot be written because their query, product or discount is missing.
+type coverageError struct {
+	Sources []uncoveredSource
+}
+
+func (e *coverageError) Error() string {
+	lines := make([]string, len(e.Sources))
+	for i, s := range e.Sources {
+		lines[i] = fmt.Sprintf("%s (no %s)", s.Source, strings.Join(s.Missing, ", no "))
+	}
+	return fmt.Sprintf("%d sources not covered by the catalog: %s", len(e.Sources), strings.Join(lines, "; "))
+}
+
+/*
+checkCoverage resolves the coverage of every source and returns a *coverageError listing all the sources not covered,
+sorted by source, or nil if all are covered. Sources are compared by their source string, the start is ignored.
+*/
+func checkCoverage(sources []AccumulateKey, resolve func(AccumulateKey) (sourceCoverage, error)) error {
+	seen := map[string]bool{}
+	var uncovered []uncoveredSource
+	for _, source := range sources {
+		if seen[source.String()] {
+			continue
+		}
+		seen[source.String()] = true
+		c, err := resolve(source)
+		if err != nil {
+			return err
+		}
+		if missing := c.missing(); len(missing) > 0 {
+			uncovered = append(uncovered, uncoveredSource{Source: source.String(), Missing: missing})
+		}
+	}
+	if len(uncovered) == 0 {
+		return nil
+	}
+	sort.Slice(uncovered, func(i, j int) bool {
+		return uncovered[i].Source < uncovered[j].Source
+	})
+	return &coverageError{Sources: uncovered}
+}
+
+// resolveSource looks up the query, product and discount of the source. Sources without product get the fallback
+// product, if configured.
+func (s *dbSink) resolveSource(ctx context.Context, tx *sqlx.Tx, source AccumulateKey) (sourceCoverage, error) {
+	var c sourceCoverage
+	var err error
+	c.query, err = queriesmodel.GetByName(ctx, tx, source.Query+":"+source.Zone)
+	if err != nil {
+		return c, err
+	}
+	c.product, err = productsmodel.GetBestMatch(ctx, tx, source.String(), source.Start)
+	if err != nil {
+		return c, err
+	}
+	if c.product == nil {
+		c.product = s.fallback
+	}
+	c.discount, err = discountsmodel.GetBestMatch(ctx, tx, source.String(), source.Start)
+	return c, err
+}
+
+// checkCoverage checks that all the sources are covered before any fact is written, see checkCoverage.
+func (s *dbSink) checkCoverage(ctx context.Context, accumulated map[AccumulateKey]uint64) error {
+	tx, err := s.rdb.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	sources := make([]AccumulateKey, 0, len(accumulated))
+	for source, value := range accumulated {
+		if value != 0 {
+			sources = append(sources, source)
+		}
+	}
+	return checkCoverage(sources, func(source AccumulateKey) (sourceCoverage, error) {
+		return s.resolveSource(ctx, tx, source)
+	})
+}
+
+// coverage resolves the coverage of the source from the catalog, see buildUsageRecords. Sources without product get
+// the fallback product, if not nil. Sources without discount are not discounted.
+func (c *catalog) coverage(source AccumulateKey, fallback *db.Product) sourceCoverage {
+	coverage := sourceCoverage{
+		query:    c.query(source.Query + ":" + source.Zone),
+		product:  c.product(source.String(), source.Start),
+		discount: c.discount(source.String(), source.Start),
+	}
+	if coverage.product == nil {
+		coverage.product = fallback
+	}
+	if coverage.discount == nil {
+		coverage.discount = &db.Discount{}
+	}
+	return coverage
+}
+
+// checkFallbackUnit checks that the quantities of all the queries can be converted into the unit of the fallback
+// product, as it is used for sources of any query. All the queries which cannot are reported at once. A fallback
+// product without unit fits any query, its quantities stay in the unit of the query.
+func checkFallbackUnit(fallback *db.Product, queries []*db.Query) error {
+	if fallback.Unit == "" {
+		return nil
+	}
+	var problems []string
+	for _, query := range queries {
+		if _, err := units.Default.Factor(query.Unit, fallback.Unit); err != nil {
+			problems = append(problems, fmt.Sprintf("query %q: %v", query.Name, err))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("unit %q of fallback product %q does not fit: %s", fallback.Unit, fallback.Source, strings.Join(problems, "; "))
+	}
+	return nil
+}
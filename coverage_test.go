@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/units"
+)
+
+func TestCheckCoverage(t *testing.T) {
+	date := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	covered := AccumulateKey{Query: sourceQueryStorage, Zone: "cloudscale", Tenant: "inity", Namespace: "ns", Start: date}
+	unknownZone := covered
+	unknownZone.Zone = "unknown"
+	unknownQuery := covered
+	unknownQuery.Query = "unknown"
+	unknownQueryNextDay := unknownQuery
+	unknownQueryNextDay.Start = date.AddDate(0, 0, 1)
+
+	c := newCatalog([]string{defaultSourceZone})
+	resolve := func(source AccumulateKey) (sourceCoverage, error) {
+		return c.coverage(source, nil), nil
+	}
+	err := checkCoverage([]AccumulateKey{covered, unknownZone, unknownQuery, unknownQueryNextDay}, resolve)
+	var coverage *coverageError
+	require.ErrorAs(t, err, &coverage)
+	assert.Equal(t, []uncoveredSource{
+		{Source: "object-storage-storage:unknown:inity:ns", Missing: []string{"query", "product"}},
+		{Source: "unknown:cloudscale:inity:ns", Missing: []string{"query", "product"}},
+	}, coverage.Sources)
+	assert.Equal(t, "2 sources not covered by the catalog: object-storage-storage:unknown:inity:ns (no query, no product); unknown:cloudscale:inity:ns (no query, no product)", err.Error())
+
+	_, err = buildUsageRecords(map[AccumulateKey]uint64{covered: 1, unknownZone: 1, unknownQuery: 1}, c, &roundingPolicies{}, nil)
+	require.ErrorAs(t, err, &coverage)
+	assert.Len(t, coverage.Sources, 2)
+
+	assert.NoError(t, checkCoverage([]AccumulateKey{covered}, resolve))
+
+	unpriced := covered
+	unpriced.Query = sourceQueryRequests
+	unpriced.Zone = "unpriced"
+	c = newCatalog([]string{defaultSourceZone, "unpriced"})
+	// the queries of the unpriced zone have no products
+	c.products = newEnsureProducts([]string{defaultSourceZone})
+	fallback := &db.Product{Source: "object-storage-unpriced", Amount: 0}
+	records, err := buildUsageRecords(map[AccumulateKey]uint64{unpriced: 2000}, c, &roundingPolicies{}, fallback)
+	require.NoError(t, err, "sources without product get the fallback product")
+	require.Len(t, records, 1)
+	assert.Equal(t, "object-storage-unpriced", records[0].Product)
+	assert.Equal(t, "0", records[0].Total.String())
+}
+
+func TestCheckFallbackUnit(t *testing.T) {
+	queries := []*db.Query{
+		{Name: "object-storage-storage:cloudscale", Unit: "GBDay"},
+		{Name: "object-storage-traffic-out:cloudscale", Unit: "GB"},
+	}
+	assert.NoError(t, checkFallbackUnit(&db.Product{Source: "unpriced"}, queries), "no unit fits all queries")
+	assert.NoError(t, checkFallbackUnit(&db.Product{Source: "unpriced", Unit: "GB"}, queries[1:]))
+	assert.EqualError(t, checkFallbackUnit(&db.Product{Source: "unpriced", Unit: "GB"}, queries),
+		`unit "GB" of fallback product "unpriced" does not fit: query "object-storage-storage:cloudscale": `+unitError(t, "GBDay", "GB"))
+}
+
+// unitError returns the error of converting between the units.
+func unitError(t *testing.T, from, to string) string {
+	_, err := units.Default.Factor(from, to)
+	require.Error(t, err)
+	return err.Error()
+}
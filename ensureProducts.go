@@ -5,28 +5,33 @@ import (
 	"github.com/appuio/appuio-cloud-reporting/pkg/db"
 )
 
-var (
-	ensureProducts = []*db.Product{
-		{
-			Source: sourceQueryStorage + ":" + sourceZones[0],
-			Target: sql.NullString{String: "1401", Valid: true},
-			Amount: 0.0033,  // this is per DAY, equals 0.099 per GB per month
-			Unit:   "GBDay", // SI GB according to cloudscale
-			During: db.InfiniteRange(),
-		},
-		{
-			Source: sourceQueryTrafficOut + ":" + sourceZones[0],
-			Target: sql.NullString{String: "1403", Valid: true},
-			Amount: 0.022,
-			Unit:   "GB", // SI GB according to cloudscale
-			During: db.InfiniteRange(),
-		},
-		{
-			Source: sourceQueryRequests + ":" + sourceZones[0],
-			Target: sql.NullString{String: "1405", Valid: true},
-			Amount: 0.0055,
-			Unit:   "KReq",
-			During: db.InfiniteRange(),
-		},
+// newEnsureProducts returns the products of all the zones. All zones have the same prices.
+func newEnsureProducts(zones []string) []*db.Product {
+	var products []*db.Product
+	for _, zone := range zones {
+		products = append(products,
+			&db.Product{
+				Source: sourceQueryStorage + ":" + zone,
+				Target: sql.NullString{String: "1401", Valid: true},
+				Amount: 0.0033,  // this is per DAY, equals 0.099 per GB per month
+				Unit:   "GBDay", // SI GB according to cloudscale
+				During: db.InfiniteRange(),
+			},
+			&db.Product{
+				Source: sourceQueryTrafficOut + ":" + zone,
+				Target: sql.NullString{String: "1403", Valid: true},
+				Amount: 0.022,
+				Unit:   "GB", // SI GB according to cloudscale
+				During: db.InfiniteRange(),
+			},
+			&db.Product{
+				Source: sourceQueryRequests + ":" + zone,
+				Target: sql.NullString{String: "1405", Valid: true},
+				Amount: 0.0055,
+				Unit:   "KReq",
+				During: db.InfiniteRange(),
+			},
+		)
 	}
-)
+	return products
+}
@@ -2,28 +2,37 @@ package main
 
 import "github.com/appuio/appuio-cloud-reporting/pkg/db"
 
-var (
-	ensureQueries = []*db.Query{
-		{
-			Name:        sourceQueryStorage + ":" + sourceZones[0],
-			Description: "Object Storage - Storage (cloudscale.ch)",
-			Query:       "",
-			Unit:        "GBDay",
-			During:      db.InfiniteRange(),
-		},
-		{
-			Name:        sourceQueryTrafficOut + ":" + sourceZones[0],
-			Description: "Object Storage - Traffic Out (cloudscale.ch)",
-			Query:       "",
-			Unit:        "GB",
-			During:      db.InfiniteRange(),
-		},
-		{
-			Name:        sourceQueryRequests + ":" + sourceZones[0],
-			Description: "Object Storage - Requests (cloudscale.ch)",
-			Query:       "",
-			Unit:        "KReq",
-			During:      db.InfiniteRange(),
-		},
+// newEnsureQueries returns the queries of all the zones. The zone is added to the description of all but the first zone.
+func newEnsureQueries(zones []string) []*db.Query {
+	var queries []*db.Query
+	for i, zone := range zones {
+		provider := "cloudscale.ch"
+		if i > 0 {
+			provider += ", " + zone
+		}
+		queries = append(queries,
+			&db.Query{
+				Name:        sourceQueryStorage + ":" + zone,
+				Description: "Object Storage - Storage (" + provider + ")",
+				Query:       "",
+				Unit:        "GBDay",
+				During:      db.InfiniteRange(),
+			},
+			&db.Query{
+				Name:        sourceQueryTrafficOut + ":" + zone,
+				Description: "Object Storage - Traffic Out (" + provider + ")",
+				Query:       "",
+				Unit:        "GB",
+				During:      db.InfiniteRange(),
+			},
+			&db.Query{
+				Name:        sourceQueryRequests + ":" + zone,
+				Description: "Object Storage - Requests (" + provider + ")",
+				Query:       "",
+				Unit:        "KReq",
+				During:      db.InfiniteRange(),
+			},
+		)
 	}
-)
+	return queries
+}
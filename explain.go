@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/discountsmodel"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/productsmodel"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/tokenmatcher"
+)
+
+// explainCandidate is a product or discount which could match the explained source.
+type explainCandidate struct {
+	Source  string
+	Details string
+	// Rank is the position of the candidate among the candidates matching the source in order of precedence, starting
+	// at 0 for the best match. It is -1 if the candidate does not match.
+	Rank int
+}
+
+// explanation shows how the product and discount of a source are chosen.
+type explanation struct {
+	Source    string
+	Date      time.Time
+	Products  []explainCandidate
+	Discounts []explainCandidate
+	// Product and Discount are the indexes of the best matching candidates, -1 if none matches.
+	Product  int
+	Discount int
+}
+
+/*
+explainCommand shows how the product and discount of a source are chosen at a date: all the candidates, the matching
+ones in order of precedence, and the best matches. The candidates are read from the database,
+or from the catalog compiled into the collector with -catalog.
+*/
+func explainCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("explain", flag.ContinueOnError)
+	source := flags.String("source", "", "source to explain in the form query:zone:tenant:namespace")
+	dateStr := flags.String("date", "", "date in the form YYYY-MM-DD (default: today)")
+	fromCatalog := flags.Bool("catalog", false, "use the catalog compiled into the collector instead of the database")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *source == "" {
+		return fmt.Errorf("-source must be set")
+	}
+	catalog, err := loadCatalog()
+	if err != nil {
+		return err
+	}
+
+	location, err := time.LoadLocation("Europe/Zurich")
+	if err != nil {
+		return err
+	}
+	date := time.Now().In(location)
+	if *dateStr != "" {
+		date, err = time.ParseInLocation("2006-01-02", *dateStr, location)
+		if err != nil {
+			return fmt.Errorf("invalid date %q: %w", *dateStr, err)
+		}
+	}
+
+	var products []*db.Product
+	var discounts []*db.Discount
+	if *fromCatalog {
+		products, discounts = catalogCandidates(catalog, *source, date)
+	} else {
+		products, discounts, err = databaseCandidates(ctx, *source, date)
+		if err != nil {
+			return err
+		}
+	}
+
+	return renderExplanation(os.Stdout, explain(*source, date, products, discounts))
+}
+
+func databaseCandidates(ctx context.Context, source string, date time.Time) ([]*db.Product, []*db.Discount, error) {
+	dbUrl, err := getenvSecret(dbUrlEnvVariable)
+	if err != nil {
+		return nil, nil, err
+	}
+	if dbUrl == "" {
+		return nil, nil, fmt.Errorf("missing env var %q", dbUrlEnvVariable)
+	}
+	rdb, err := openDatabase(dbUrl)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rdb.Close()
+	tx, err := rdb.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	products, err := productsmodel.GetCandidates(ctx, tx, source, date)
+	if err != nil {
+		return nil, nil, err
+	}
+	discounts, err := discountsmodel.GetCandidates(ctx, tx, source, date)
+	if err != nil {
+		return nil, nil, err
+	}
+	productPtrs := make([]*db.Product, len(products))
+	for i := range products {
+		productPtrs[i] = &products[i]
+	}
+	discountPtrs := make([]*db.Discount, len(discounts))
+	for i := range discounts {
+		discountPtrs[i] = &discounts[i]
+	}
+	return productPtrs, discountPtrs, nil
+}
+
+// catalogCandidates returns the products and discounts of the catalog valid at date with the same query as source.
+func catalogCandidates(c *catalog, source string, date time.Time) ([]*db.Product, []*db.Discount) {
+	query := tokenmatcher.NewTokenizedSource(source).Tokens[0]
+	sameQuery := func(candidate string) bool {
+		return candidate == query || strings.HasPrefix(candidate, query+":")
+	}
+	var products []*db.Product
+	for _, product := range c.products {
+		if sameQuery(product.Source) && rangeContains(product.During, date) {
+			products = append(products, product)
+		}
+	}
+	var discounts []*db.Discount
+	for _, discount := range c.discounts {
+		if sameQuery(discount.Source) && rangeContains(discount.During, date) {
+			discounts = append(discounts, discount)
+		}
+	}
+	return products, discounts
+}
+
+// explain ranks the candidates matching the source by precedence, the same way GetBestMatch chooses among them.
+func explain(source string, date time.Time, products []*db.Product, discounts []*db.Discount) *explanation {
+	e := &explanation{Source: source, Date: date}
+	for _, product := range products {
+		e.Products = append(e.Products, explainCandidate{Source: product.Source, Details: fmt.Sprintf("target %s, amount %g %s", product.Target.String, product.Amount, product.Unit)})
+	}
+	for _, discount := range discounts {
+		e.Discounts = append(e.Discounts, explainCandidate{Source: discount.Source, Details: fmt.Sprintf("discount %g", discount.Discount)})
+	}
+	e.Product = rankCandidates(source, e.Products)
+	e.Discount = rankCandidates(source, e.Discounts)
+	return e
+}
+
+// rankCandidates sets the rank of the candidates and returns the index of the best match, -1 if none matches.
+func rankCandidates(source string, candidates []explainCandidate) int {
+	sources := make([]*tokenmatcher.TokenizedSource, len(candidates))
+	for i := range candidates {
+		candidates[i].Rank = -1
+		sources[i] = tokenmatcher.NewTokenizedSource(candidates[i].Source)
+	}
+	ranked := tokenmatcher.Rank(tokenmatcher.NewTokenizedSource(source), sources)
+	for rank, i := range ranked {
+		candidates[i].Rank = rank
+	}
+	if len(ranked) == 0 {
+		return -1
+	}
+	return ranked[0]
+}
+
+func renderExplanation(w io.Writer, e *explanation) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Source %s at %s\n", e.Source, e.Date.Format("2006-01-02"))
+
+	renderCandidates := func(kind string, candidates []explainCandidate, best int) {
+		fmt.Fprintf(tw, "\n%s (%d candidates, matches in order of precedence)\n", kind, len(candidates))
+		// the matches in order of precedence, followed by the other candidates
+		ordered := make([]int, len(candidates))
+		for i := range ordered {
+			ordered[i] = i
+		}
+		sort.SliceStable(ordered, func(a, b int) bool {
+			rankA, rankB := candidates[ordered[a]].Rank, candidates[ordered[b]].Rank
+			return rankA >= 0 && (rankB < 0 || rankA < rankB)
+		})
+		for _, i := range ordered {
+			c := candidates[i]
+			rank, match := "-", "no match"
+			if c.Rank >= 0 {
+				rank, match = strconv.Itoa(c.Rank+1), ""
+			}
+			marker := ""
+			if i == best {
+				marker = "<- best match"
+			}
+			fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%s\n", rank, c.Source, c.Details, match, marker)
+		}
+	}
+	renderCandidates("Products", e.Products, e.Product)
+	renderCandidates("Discounts", e.Discounts, e.Discount)
+	return tw.Flush()
+}
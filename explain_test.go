@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplain(t *testing.T) {
+	date := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	products := []*db.Product{
+		{Source: "object-storage-storage:cloudscale", Amount: 0.0033, Unit: "GBDay"},
+		{Source: "object-storage-storage:cloudscale:*:ns", Amount: 0.002, Unit: "GBDay"},
+		{Source: "object-storage-storage:cloudscale:vshn", Amount: 0.001, Unit: "GBDay"},
+	}
+	discounts := []*db.Discount{
+		{Source: "object-storage-storage", Discount: 0},
+	}
+
+	e := explain("object-storage-storage:cloudscale:inity:ns", date, products, discounts)
+	assert.Equal(t, 1, e.Product)
+	assert.Equal(t, 0, e.Products[1].Rank)
+	assert.Equal(t, 1, e.Products[0].Rank)
+	assert.Equal(t, -1, e.Products[2].Rank, "another tenant")
+	assert.Equal(t, 0, e.Discount)
+
+	var buf bytes.Buffer
+	require.NoError(t, renderExplanation(&buf, e))
+	assert.Regexp(t, `1 +object-storage-storage:cloudscale:\*:ns +target , amount 0.002 GBDay +<- best match *\n`+
+		` +2 +object-storage-storage:cloudscale +target , amount 0.0033 GBDay *\n`+
+		` +- +object-storage-storage:cloudscale:vshn +target , amount 0.001 GBDay +no match`, buf.String())
+	assert.Regexp(t, `1 +object-storage-storage +discount 0 +<- best match`, buf.String())
+
+	products = append(products, &db.Product{Source: "object-storage-storage:{cloudscale,cloudscale-lpg}:*:ns", Amount: 0.001, Unit: "GBDay"})
+	e = explain("object-storage-storage:cloudscale-lpg:inity:ns", date, products, discounts)
+	assert.Equal(t, 3, e.Product)
+	assert.Equal(t, 0, e.Products[3].Rank)
+	assert.Equal(t, -1, e.Products[0].Rank)
+	buf.Reset()
+	require.NoError(t, renderExplanation(&buf, e))
+	assert.Regexp(t, `1 +object-storage-storage:\{cloudscale,cloudscale-lpg\}:\*:ns +target , amount 0.001 GBDay +<- best match`, buf.String())
+
+	// sources with more tokens than there are wildcard patterns supported
+	source := "a:b:c:d:e:f:g:h:i:j:k"
+	e = explain(source, date, []*db.Product{{Source: "a:*:c"}, {Source: source}, {Source: "a:b:c:*:e"}}, nil)
+	assert.Equal(t, 1, e.Product)
+	assert.Equal(t, []int{2, 0, 1}, []int{e.Products[0].Rank, e.Products[1].Rank, e.Products[2].Rank})
+	assert.Equal(t, -1, e.Discount)
+	buf.Reset()
+	require.NoError(t, renderExplanation(&buf, e))
+	assert.Contains(t, buf.String(), "Discounts (0 candidates")
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/units"
+)
+
+const (
+	// outputDB writes facts to the appuio-cloud-reporting database.
+	outputDB = "db"
+	// outputCSV writes usage records as CSV.
+	outputCSV = "csv"
+	// outputJSONL writes usage records as JSON Lines.
+	outputJSONL = "jsonl"
+	// outputWebhook posts usage records to an HTTP endpoint.
+	outputWebhook = "webhook"
+)
+
+// usageRecord is one accumulated value together with its quantity and price as resolved from the catalog.
+// Decimal numbers are kept as json.Number, so they are written exactly.
+type usageRecord struct {
+	Date string `json:"date"`
+	// Hour is the hour in UTC the value starts at, like the hour of the date_times of facts. Date is the day in
+	// Europe/Zurich, so a daily value of 2022-10-01 starts at hour 22 of the previous day in UTC.
+	Hour      int    `json:"hour"`
+	Source    string `json:"source"`
+	Query     string `json:"query"`
+	Zone      string `json:"zone"`
+	Tenant    string `json:"tenant"`
+	Namespace string `json:"namespace"`
+
+	// Value is the raw value in RawUnit as returned by cloudscale.
+	Value   uint64 `json:"value"`
+	RawUnit string `json:"raw_unit"`
+
+	// Quantity is the converted and rounded value in Unit.
+	Quantity json.Number `json:"quantity"`
+	Unit     string      `json:"unit"`
+
+	Product  string      `json:"product"`
+	Target   string      `json:"target"`
+	Price    json.Number `json:"price"`
+	Discount json.Number `json:"discount"`
+	// Total is Quantity * Price * (1 - Discount).
+	Total json.Number `json:"total"`
+
+	start time.Time
+}
+
+var usageRecordHeader = []string{"date", "hour", "source", "query", "zone", "tenant", "namespace", "value", "raw_unit", "quantity", "unit", "product", "target", "price", "discount", "total"}
+
+func (r usageRecord) csvRow() []string {
+	return []string{r.Date, strconv.Itoa(r.Hour), r.Source, r.Query, r.Zone, r.Tenant, r.Namespace, strconv.FormatUint(r.Value, 10), r.RawUnit, r.Quantity.String(), r.Unit, r.Product, r.Target, r.Price.String(), r.Discount.String(), r.Total.String()}
+}
+
+// buildUsageRecords converts the accumulated values into usage records, resolving queries, products and discounts
+// from the catalog. Sources without product get the fallback product, if not nil. The records are sorted by start and
+// source. If any source is not covered by the catalog, a *coverageError lists all of them.
+func buildUsageRecords(accumulated map[AccumulateKey]uint64, catalog *catalog, rounding *roundingPolicies, fallback *db.Product) ([]usageRecord, error) {
+	sources := make([]AccumulateKey, 0, len(accumulated))
+	for source, value := range accumulated {
+		if value != 0 {
+			sources = append(sources, source)
+		}
+	}
+	err := checkCoverage(sources, func(source AccumulateKey) (sourceCoverage, error) {
+		return catalog.coverage(source, fallback), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]usageRecord, 0, len(accumulated))
+	for source, value := range accumulated {
+		if value == 0 {
+			continue
+		}
+
+		// all sources are covered
+		c := catalog.coverage(source, fallback)
+		query, product := c.query, c.product
+		discount := units.FromFloat(c.discount.Discount)
+
+		rawUnit := sourceUnits[source.Query]
+		converted, err := units.Default.Convert(value, rawUnit, query.Unit)
+		if err != nil {
+			return nil, fmt.Errorf("cannot convert %s: %w", source, err)
+		}
+		quantity := rounding.forProduct(product.Source).Round(converted)
+		price := units.FromFloat(product.Amount)
+		total := new(big.Rat).Mul(quantity, price)
+		total.Mul(total, new(big.Rat).Sub(big.NewRat(1, 1), discount))
+
+		records = append(records, usageRecord{
+			Date:      source.Start.Format("2006-01-02"),
+			Hour:      source.Start.UTC().Hour(),
+			Source:    source.String(),
+			Query:     source.Query,
+			Zone:      source.Zone,
+			Tenant:    source.Tenant,
+			Namespace: source.Namespace,
+			Value:     value,
+			RawUnit:   rawUnit,
+			Quantity:  json.Number(units.FormatDecimal(quantity)),
+			Unit:      query.Unit,
+			Product:   product.Source,
+			Target:    product.Target.String,
+			Price:     json.Number(units.FormatDecimal(price)),
+			Discount:  json.Number(units.FormatDecimal(discount)),
+			Total:     json.Number(units.FormatDecimal(total)),
+			start:     source.Start,
+		})
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].start.Equal(records[j].start) {
+			return records[i].start.Before(records[j].start)
+		}
+		return records[i].Source < records[j].Source
+	})
+	return records, nil
+}
+
+// writeUsageRecords writes the records in the given format (outputCSV or outputJSONL).
+func writeUsageRecords(w io.Writer, format string, records []usageRecord) error {
+	switch format {
+	case outputCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(usageRecordHeader); err != nil {
+			return err
+		}
+		for _, record := range records {
+			if err := cw.Write(record.csvRow()); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case outputJSONL:
+		enc := json.NewEncoder(w)
+		for _, record := range records {
+			if err := enc.Encode(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q", format)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildAndWriteUsageRecords(t *testing.T) {
+	location, err := time.LoadLocation("Europe/Zurich")
+	require.NoError(t, err, "could not load location Europe/Zurich")
+	date := time.Date(2022, 10, 1, 0, 0, 0, 0, location)
+
+	key := AccumulateKey{Zone: "cloudscale", Tenant: "inity", Namespace: "testnamespace", Start: date}
+	storage, trafficOut, requests, empty := key, key, key, key
+	storage.Query = sourceQueryStorage
+	trafficOut.Query = sourceQueryTrafficOut
+	requests.Query = sourceQueryRequests
+	empty.Namespace = "empty"
+	empty.Query = sourceQueryRequests
+	accumulated := map[AccumulateKey]uint64{
+		storage:    1500000000,
+		trafficOut: 2000000,
+		requests:   5,
+		empty:      0,
+	}
+
+	rounding, err := parseRoundingPolicies("none,object-storage-traffic-out:cloudscale=up:0")
+	require.NoError(t, err)
+	records, err := buildUsageRecords(accumulated, newCatalog([]string{defaultSourceZone}), rounding, nil)
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+
+	assert.Equal(t, "object-storage-requests:cloudscale:inity:testnamespace", records[0].Source)
+	assert.Equal(t, "0.005", records[0].Quantity.String())
+	assert.Equal(t, "KReq", records[0].Unit)
+	assert.Equal(t, "0.0000275", records[0].Total.String())
+
+	assert.Equal(t, "object-storage-storage:cloudscale:inity:testnamespace", records[1].Source)
+	assert.Equal(t, "1.5", records[1].Quantity.String())
+	assert.Equal(t, "0.0033", records[1].Price.String())
+	assert.Equal(t, "0.00495", records[1].Total.String())
+	assert.Equal(t, "1401", records[1].Target)
+
+	assert.Equal(t, "object-storage-traffic-out:cloudscale:inity:testnamespace", records[2].Source)
+	assert.Equal(t, "1", records[2].Quantity.String(), "started GB must be billed")
+	assert.Equal(t, "0.022", records[2].Total.String())
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, writeUsageRecords(buf, outputCSV, records[1:2]))
+	assert.Equal(t, "date,hour,source,query,zone,tenant,namespace,value,raw_unit,quantity,unit,product,target,price,discount,total\n"+
+		"2022-10-01,22,object-storage-storage:cloudscale:inity:testnamespace,object-storage-storage,cloudscale,inity,testnamespace,1500000000,BDay,1.5,GBDay,object-storage-storage:cloudscale,1401,0.0033,0,0.00495\n",
+		buf.String())
+
+	buf.Reset()
+	require.NoError(t, writeUsageRecords(buf, outputJSONL, records[1:2]))
+	assert.JSONEq(t, `{"date":"2022-10-01","hour":22,"source":"object-storage-storage:cloudscale:inity:testnamespace","query":"object-storage-storage","zone":"cloudscale","tenant":"inity","namespace":"testnamespace","value":1500000000,"raw_unit":"BDay","quantity":1.5,"unit":"GBDay","product":"object-storage-storage:cloudscale","target":"1401","price":0.0033,"discount":0,"total":0.00495}`,
+		buf.String())
+}
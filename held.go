@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/vshn/cloudscale-metrics-collector/pkg/heldfactsmodel"
+)
+
+/*
+heldFactsCommand manages the facts held back because of anomalies:
+
+	held-facts [list]            lists the facts waiting for approval
+	held-facts approve <id>...   writes the facts to the database
+	held-facts reject <id>...    discards the facts, they won't be written by later runs either
+*/
+func heldFactsCommand(ctx context.Context, args []string) error {
+	action := "list"
+	if len(args) > 0 {
+		action = args[0]
+		args = args[1:]
+	}
+
+	catalog, err := loadCatalog()
+	if err != nil {
+		return err
+	}
+	dbUrl, err := getenvSecret(dbUrlEnvVariable)
+	if err != nil {
+		return err
+	}
+	if dbUrl == "" {
+		return fmt.Errorf("missing env var %q", dbUrlEnvVariable)
+	}
+
+	switch action {
+	case "list":
+		return listHeldFacts(ctx, dbUrl)
+	case "approve", "reject":
+		if len(args) == 0 {
+			return fmt.Errorf("%s needs the ids of the held facts", action)
+		}
+		rounding, err := parseRoundingPolicies(os.Getenv(roundingEnvVariable))
+		if err == nil {
+			err = rounding.checkProducts(catalog.products, os.Getenv(fallbackProductEnvVariable))
+		}
+		if err != nil {
+			return fmt.Errorf("env var %q: %w", roundingEnvVariable, err)
+		}
+		return decideHeldFacts(ctx, &config{catalog: catalog, databaseURL: dbUrl, rounding: rounding, fallbackProduct: os.Getenv(fallbackProductEnvVariable)}, action == "approve", args)
+	}
+	return fmt.Errorf("unknown action %q, must be one of: list, approve, reject", action)
+}
+
+func listHeldFacts(ctx context.Context, dbUrl string) error {
+	rdb, err := openDatabase(dbUrl)
+	if err != nil {
+		return err
+	}
+	defer rdb.Close()
+	tx, err := rdb.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	heldFacts, err := heldfactsmodel.GetByStatus(ctx, tx, heldfactsmodel.StatusHeld)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "ID\tDate\tSource\tQuantity\tBaseline\t\n")
+	for _, f := range heldFacts {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%g\t%g\t\n", f.Id, f.Timestamp.Format("2006-01-02"), f.Source(), f.Quantity, f.Baseline)
+	}
+	return tw.Flush()
+}
+
+// decideHeldFacts approves or rejects the held facts. Approved facts are written within a new run, like facts
+// collected by sync.
+func decideHeldFacts(ctx context.Context, cfg *config, approve bool, ids []string) error {
+	sink := newDBSink(cfg)
+	if err := sink.Open(ctx); err != nil {
+		return err
+	}
+	err := decideHeldFactsWithSink(ctx, sink, approve, ids)
+	closeErr := sink.Close(ctx, nil, err)
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+func decideHeldFactsWithSink(ctx context.Context, sink *dbSink, approve bool, ids []string) error {
+	status := heldfactsmodel.StatusRejected
+	if approve {
+		status = heldfactsmodel.StatusApproved
+	}
+	for _, id := range ids {
+		tx, err := sink.rdb.BeginTxx(ctx, &sql.TxOptions{})
+		if err != nil {
+			return err
+		}
+		heldFact, err := heldfactsmodel.GetById(ctx, tx, id)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if heldFact == nil || heldFact.Status != heldfactsmodel.StatusHeld {
+			tx.Rollback()
+			return fmt.Errorf("no held fact with id %s", id)
+		}
+		var written writtenFact
+		if approve {
+			source := AccumulateKey{
+				Query:     heldFact.Query,
+				Zone:      heldFact.Zone,
+				Tenant:    heldFact.Tenant,
+				Namespace: heldFact.Namespace,
+				Start:     heldFact.Timestamp,
+			}
+			// the fact is written in the transaction of the decision, so neither is committed without the other
+			written, err = sink.writeFactTx(ctx, tx, source, uint64(heldFact.Value))
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if err := heldfactsmodel.Decide(ctx, tx, id, status); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		sink.run.countFact(written)
+		fmt.Printf("%s %s\n", status, heldFact.Source())
+	}
+	return nil
+}
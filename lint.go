@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/discountsmodel"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/productsmodel"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/tokenmatcher"
+)
+
+// sourceTokens is the number of tokens of the sources written by the collector: query:zone:tenant:namespace.
+const sourceTokens = 4
+
+// unmatchedToken is a tenant or namespace matched only by wildcards, used to construct sources in reachableBy.
+const unmatchedToken = "\x00"
+
+// catalogEntry is the source and validity of a product or discount.
+type catalogEntry struct {
+	Source string
+	During pgtype.Tstzrange
+}
+
+/*
+lintCommand checks the products and discounts for entries with the same source and overlapping validity, gaps in the
+validity of entries with the same source, entries which are never the best match of a source written by the
+collector, and entries which are equally specific and match the same sources. The products and discounts are read from
+the database, or from the catalog compiled into the collector with -catalog. It prints all the problems found and fails
+if there are any.
+*/
+func lintCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	fromCatalog := flags.Bool("catalog", false, "use the catalog compiled into the collector instead of the database")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	catalog, err := loadCatalog()
+	if err != nil {
+		return err
+	}
+
+	var products, discounts []catalogEntry
+	if *fromCatalog {
+		for _, product := range catalog.products {
+			products = append(products, catalogEntry{Source: product.Source, During: product.During})
+		}
+		for _, discount := range catalog.discounts {
+			discounts = append(discounts, catalogEntry{Source: discount.Source, During: discount.During})
+		}
+	} else {
+		products, discounts, err = databaseCatalog(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	queries := []string{sourceQueryStorage, sourceQueryTrafficOut, sourceQueryRequests}
+	problems := append(lintCatalog("product", products, queries, catalog.zones), lintCatalog("discount", discounts, queries, catalog.zones)...)
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d problems found in %d products and %d discounts", len(problems), len(products), len(discounts))
+	}
+	fmt.Printf("no problems found in %d products and %d discounts\n", len(products), len(discounts))
+	return nil
+}
+
+func databaseCatalog(ctx context.Context) ([]catalogEntry, []catalogEntry, error) {
+	dbUrl, err := getenvSecret(dbUrlEnvVariable)
+	if err != nil {
+		return nil, nil, err
+	}
+	if dbUrl == "" {
+		return nil, nil, fmt.Errorf("missing env var %q", dbUrlEnvVariable)
+	}
+	rdb, err := openDatabase(dbUrl)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rdb.Close()
+	tx, err := rdb.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	dbProducts, err := productsmodel.GetAll(ctx, tx)
+	if err != nil {
+		return nil, nil, err
+	}
+	dbDiscounts, err := discountsmodel.GetAll(ctx, tx)
+	if err != nil {
+		return nil, nil, err
+	}
+	var products, discounts []catalogEntry
+	for _, product := range dbProducts {
+		products = append(products, catalogEntry{Source: product.Source, During: product.During})
+	}
+	for _, discount := range dbDiscounts {
+		discounts = append(discounts, catalogEntry{Source: discount.Source, During: discount.During})
+	}
+	return products, discounts, nil
+}
+
+// lintCatalog returns the problems of the products or discounts (kind), for sources of the queries and zones.
+func lintCatalog(kind string, entries []catalogEntry, queries, zones []string) []string {
+	var problems []string
+	var valid []catalogEntry
+	for _, e := range entries {
+		if rangeEmpty(e.During) {
+			problems = append(problems, fmt.Sprintf("%s %q: unreachable, the validity is empty", kind, e.Source))
+			continue
+		}
+		valid = append(valid, e)
+	}
+
+	bySource := map[string][]catalogEntry{}
+	var sources []string
+	for _, e := range valid {
+		if _, ok := bySource[e.Source]; !ok {
+			sources = append(sources, e.Source)
+		}
+		bySource[e.Source] = append(bySource[e.Source], e)
+	}
+	for _, source := range sources {
+		for _, problem := range lintValidity(bySource[source]) {
+			problems = append(problems, fmt.Sprintf("%s %q: %s", kind, source, problem))
+		}
+	}
+
+	for i, e := range valid {
+		if reason := unreachable(i, valid, queries, zones); reason != "" {
+			problems = append(problems, fmt.Sprintf("%s %q valid %s: unreachable, %s", kind, e.Source, formatRange(e.During), reason))
+		}
+	}
+	for i, a := range valid {
+		for _, b := range valid[i+1:] {
+			if rangesOverlap(a.During, b.During) && tie(a.Source, b.Source) {
+				first, second := a.Source, b.Source
+				if second < first {
+					first, second = second, first
+				}
+				problems = append(problems, fmt.Sprintf("%s %q ties with %q: equally specific, %q is used for the sources both match", kind, a.Source, b.Source, first))
+			}
+		}
+	}
+	return problems
+}
+
+// tie returns whether the sources are equally specific (see tokenmatcher.Tie) and a source constructed from the
+// examples of one of them is matched by the other. Wildcards are tried with a token matched by wildcards only.
+func tie(a, b string) bool {
+	sa, sb := tokenmatcher.NewTokenizedSource(a), tokenmatcher.NewTokenizedSource(b)
+	if !tokenmatcher.Tie(sa, sb) {
+		return false
+	}
+	for _, pair := range [][2]*tokenmatcher.TokenizedSource{{sa, sb}, {sb, sa}} {
+		var examples [][]string
+		for _, token := range pair[0].Tokens {
+			tokens := []string{unmatchedToken}
+			if token != "*" {
+				tokens = tokenmatcher.Examples(token)
+			}
+			examples = append(examples, tokens)
+		}
+		for _, source := range exampleSources(examples) {
+			if tokenmatcher.Matches(source, pair[1]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lintValidity returns the overlaps and gaps of the validity of entries with the same source.
+func lintValidity(entries []catalogEntry) []string {
+	var problems []string
+	for i := range entries {
+		for j := i + 1; j < len(entries); j++ {
+			if rangesOverlap(entries[i].During, entries[j].During) {
+				problems = append(problems, fmt.Sprintf("validity %s overlaps %s", formatRange(entries[i].During), formatRange(entries[j].During)))
+			}
+		}
+	}
+
+	sorted := append([]catalogEntry(nil), entries...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return lowerBoundBefore(lowerBound(sorted[i].During), lowerBound(sorted[j].During))
+	})
+	end := upperBound(sorted[0].During)
+	for _, e := range sorted[1:] {
+		if boundsGap(end, lowerBound(e.During)) {
+			problems = append(problems, fmt.Sprintf("not valid between %s and %s", formatBound(end), formatBound(lowerBound(e.During))))
+		}
+		if upperBoundAfter(upperBound(e.During), end) {
+			end = upperBound(e.During)
+		}
+	}
+	return problems
+}
+
+/*
+unreachable returns why the i-th entry is never the best match of a source written by the collector, or "" if it
+can be. It tries sources with all the queries and zones, and tenants and namespaces matched by the entry (see
+tokenmatcher.Examples), against the entries valid during the entry's whole validity. Entries with globs no example can
+be constructed for are considered reachable, as well as globs whose example is only shadowed by an exact token, see
+sampledGlob.
+*/
+func unreachable(i int, entries []catalogEntry, queries, zones []string) string {
+	e := tokenmatcher.NewTokenizedSource(entries[i].Source)
+	if err := tokenmatcher.Validate(e); err != nil {
+		return err.Error()
+	}
+	if len(e.Tokens) > sourceTokens {
+		return fmt.Sprintf("more than %d tokens (query:zone:tenant:namespace)", sourceTokens)
+	}
+	if !containsString(queries, e.Tokens[0]) {
+		return fmt.Sprintf("query %q is not collected", e.Tokens[0])
+	}
+
+	// the examples of every position of the sources to try
+	examples := [][]string{{e.Tokens[0]}, zones}
+	for p := 2; p < sourceTokens; p++ {
+		if p >= len(e.Tokens) || e.Tokens[p] == "*" {
+			examples = append(examples, []string{unmatchedToken})
+			continue
+		}
+		tokens := tokenmatcher.Examples(e.Tokens[p])
+		if tokens == nil {
+			return ""
+		}
+		examples = append(examples, tokens)
+	}
+
+	candidates := []*tokenmatcher.TokenizedSource{e}
+	for j, other := range entries {
+		if j != i && rangeContainsRange(other.During, entries[i].During) {
+			candidates = append(candidates, tokenmatcher.NewTokenizedSource(other.Source))
+		}
+	}
+
+	matched := false
+	var winners []string
+	for _, source := range exampleSources(examples) {
+		if !tokenmatcher.Matches(source, e) {
+			continue
+		}
+		matched = true
+		best := tokenmatcher.FindBestMatch(source, candidates)
+		if best == e || sampledGlob(e, best) {
+			return ""
+		}
+		if winner := fmt.Sprintf("%q", best.String()); !containsString(winners, winner) {
+			winners = append(winners, winner)
+		}
+	}
+	if !matched {
+		return fmt.Sprintf("matches none of the zones %s", strings.Join(zones, ", "))
+	}
+	return "always shadowed by " + strings.Join(winners, ", ")
+}
+
+// sampledGlob returns whether the winner has an exact token where the entry has a glob. The winner then only wins for
+// the example of the glob, and the entry is reachable by the other tokens the glob matches.
+func sampledGlob(entry, winner *tokenmatcher.TokenizedSource) bool {
+	for p := 2; p < len(entry.Tokens) && p < len(winner.Tokens); p++ {
+		if tokenmatcher.IsGlob(entry.Tokens[p]) && tokenmatcher.IsExact(winner.Tokens[p]) {
+			return true
+		}
+	}
+	return false
+}
+
+// exampleSources returns all the combinations of the examples of every position.
+func exampleSources(examples [][]string) []*tokenmatcher.TokenizedSource {
+	sources := [][]string{nil}
+	for _, tokens := range examples {
+		var next [][]string
+		for _, source := range sources {
+			for _, token := range tokens {
+				next = append(next, append(append([]string(nil), source...), token))
+			}
+		}
+		sources = next
+	}
+	result := make([]*tokenmatcher.TokenizedSource, len(sources))
+	for i, tokens := range sources {
+		result[i] = &tokenmatcher.TokenizedSource{Tokens: tokens}
+	}
+	return result
+}
+
+// rangeBound is the lower or upper bound of a time range.
+type rangeBound struct {
+	time      time.Time
+	unbounded bool
+	inclusive bool
+}
+
+func lowerBound(r pgtype.Tstzrange) rangeBound {
+	return rangeBound{
+		time:      r.Lower.Time,
+		unbounded: r.LowerType == pgtype.Unbounded || r.Lower.InfinityModifier == pgtype.NegativeInfinity,
+		inclusive: r.LowerType == pgtype.Inclusive,
+	}
+}
+
+func upperBound(r pgtype.Tstzrange) rangeBound {
+	return rangeBound{
+		time:      r.Upper.Time,
+		unbounded: r.UpperType == pgtype.Unbounded || r.Upper.InfinityModifier == pgtype.Infinity,
+		inclusive: r.UpperType == pgtype.Inclusive,
+	}
+}
+
+// rangeEmpty returns whether the time range contains no time at all.
+func rangeEmpty(r pgtype.Tstzrange) bool {
+	if r.Status != pgtype.Present || r.LowerType == pgtype.Empty ||
+		r.Lower.InfinityModifier == pgtype.Infinity || r.Upper.InfinityModifier == pgtype.NegativeInfinity {
+		return true
+	}
+	lower, upper := lowerBound(r), upperBound(r)
+	return !lower.unbounded && !upper.unbounded &&
+		(upper.time.Before(lower.time) || upper.time.Equal(lower.time) && !(lower.inclusive && upper.inclusive))
+}
+
+// boundsGap returns whether there is a time after the upper bound and before the lower bound.
+func boundsGap(upper, lower rangeBound) bool {
+	if upper.unbounded || lower.unbounded {
+		return false
+	}
+	return upper.time.Before(lower.time) || upper.time.Equal(lower.time) && !upper.inclusive && !lower.inclusive
+}
+
+// rangesOverlap returns whether two non-empty time ranges have a time in common.
+func rangesOverlap(a, b pgtype.Tstzrange) bool {
+	return !boundsApart(upperBound(a), lowerBound(b)) && !boundsApart(upperBound(b), lowerBound(a))
+}
+
+// boundsApart returns whether no time is both before the upper and after the lower bound.
+func boundsApart(upper, lower rangeBound) bool {
+	if upper.unbounded || lower.unbounded {
+		return false
+	}
+	return upper.time.Before(lower.time) || upper.time.Equal(lower.time) && !(upper.inclusive && lower.inclusive)
+}
+
+// rangeContainsRange returns whether the time range a contains all of the time range b.
+func rangeContainsRange(a, b pgtype.Tstzrange) bool {
+	aLower, bLower := lowerBound(a), lowerBound(b)
+	aUpper, bUpper := upperBound(a), upperBound(b)
+	return !lowerBoundBefore(bLower, aLower) && !upperBoundAfter(bUpper, aUpper)
+}
+
+// lowerBoundBefore returns whether the lower bound a includes a time the lower bound b does not.
+func lowerBoundBefore(a, b rangeBound) bool {
+	if a.unbounded || b.unbounded {
+		return a.unbounded && !b.unbounded
+	}
+	return a.time.Before(b.time) || a.time.Equal(b.time) && a.inclusive && !b.inclusive
+}
+
+// upperBoundAfter returns whether the upper bound a includes a time the upper bound b does not.
+func upperBoundAfter(a, b rangeBound) bool {
+	if a.unbounded || b.unbounded {
+		return a.unbounded && !b.unbounded
+	}
+	return a.time.After(b.time) || a.time.Equal(b.time) && a.inclusive && !b.inclusive
+}
+
+func formatRange(r pgtype.Tstzrange) string {
+	lower, upper := "(", ")"
+	if r.LowerType == pgtype.Inclusive {
+		lower = "["
+	}
+	if r.UpperType == pgtype.Inclusive {
+		upper = "]"
+	}
+	from := formatBound(lowerBound(r))
+	if lowerBound(r).unbounded {
+		from = "-infinity"
+	}
+	return lower + from + ", " + formatBound(upperBound(r)) + upper
+}
+
+func formatBound(b rangeBound) string {
+	if b.unbounded {
+		return "infinity"
+	}
+	return b.time.Format(time.RFC3339)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+	"github.com/jackc/pgtype"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLintCatalog(t *testing.T) {
+	at := func(month int) pgtype.Timestamptz {
+		return db.MustTimestamp(time.Date(2022, time.Month(month), 1, 0, 0, 0, 0, time.UTC))
+	}
+	always := db.InfiniteRange()
+	since := func(month int) pgtype.Tstzrange {
+		return db.Timerange(at(month), db.MustTimestamp(pgtype.Infinity))
+	}
+
+	entries := []catalogEntry{
+		{Source: "q:z", During: always},
+		{Source: "q:z:*:ns", During: always},
+		{Source: "q:z:a", During: db.Timerange(at(1), at(7))},
+		{Source: "q:z:a", During: since(6)},
+		{Source: "q:z:b", During: db.Timerange(db.MustTimestamp(pgtype.NegativeInfinity), at(1))},
+		{Source: "q:z:b", During: since(2)},
+		{Source: "q:z:c", During: db.Timerange(at(3), at(3))},
+		{Source: "q:*:t:ns", During: always},
+		{Source: "q:z:t:ns", During: always},
+		{Source: "q:y:t:ns", During: always},
+		{Source: "q:*:u:ns", During: always},
+		{Source: "q:z:u:ns", During: since(1)},
+		{Source: "q:{y,z}:team-*:ns", During: always},
+		{Source: "q:z:team-*:ns", During: always},
+		{Source: "q:other", During: always},
+		{Source: "x:z", During: always},
+		{Source: "q:z:t:ns:extra", During: always},
+		{Source: "q:z:*", During: always},
+	}
+	assert.Equal(t, []string{
+		`product "q:z:c": unreachable, the validity is empty`,
+		`product "q:z:a": validity [2022-01-01T00:00:00Z, 2022-07-01T00:00:00Z) overlaps [2022-06-01T00:00:00Z, infinity)`,
+		`product "q:z:b": not valid between 2022-01-01T00:00:00Z and 2022-02-01T00:00:00Z`,
+		`product "q:*:t:ns" valid [-infinity, infinity): unreachable, always shadowed by "q:z:t:ns", "q:y:t:ns"`,
+		`product "q:other" valid [-infinity, infinity): unreachable, matches none of the zones z, y`,
+		`product "x:z" valid [-infinity, infinity): unreachable, query "x" is not collected`,
+		`product "q:z:t:ns:extra" valid [-infinity, infinity): unreachable, more than 4 tokens (query:zone:tenant:namespace)`,
+		`product "q:z:*" valid [-infinity, infinity): unreachable, the last token must not be a wildcard`,
+	}, lintCatalog("product", entries, []string{"q"}, []string{"z", "y"}))
+
+	assert.Equal(t, []string{
+		`discount "q:{y,z}:team-*:ns" valid [-infinity, infinity): unreachable, always shadowed by "q:z:team-*:ns"`,
+	}, lintCatalog("discount", []catalogEntry{
+		{Source: "q:{y,z}:team-*:ns", During: always},
+		{Source: "q:z:team-*:ns", During: always},
+	}, []string{"q"}, []string{"z"}))
+
+	assert.Empty(t, lintCatalog("product", []catalogEntry{
+		{Source: "q:z:team-*:ns", During: always},
+		{Source: "q:z:team-:ns", During: always},
+	}, []string{"q"}, []string{"z"}), "the glob matches other tenants than its example")
+
+	assert.Equal(t, []string{
+		`product "q:z:team-?:ns" valid [-infinity, infinity): unreachable, always shadowed by "q:z:team-*:ns"`,
+		`product "q:{y,z}:t:ns" ties with "q:{x,z}:t:ns": equally specific, "q:{x,z}:t:ns" is used for the sources both match`,
+		`product "q:z:team-*:ns" ties with "q:z:team-?:ns": equally specific, "q:z:team-*:ns" is used for the sources both match`,
+	}, lintCatalog("product", []catalogEntry{
+		{Source: "q:{y,z}:t:ns", During: always},
+		{Source: "q:{x,z}:t:ns", During: always},
+		{Source: "q:{v,w}:t:ns", During: always},
+		{Source: "q:z:team-*:ns", During: always},
+		{Source: "q:z:team-?:ns", During: always},
+		{Source: "q:z:team-*:other", During: always},
+		{Source: "q:y:team-?:ns", During: db.Timerange(at(1), at(2))},
+		{Source: "q:y:team-*:ns", During: since(2)},
+	}, []string{"q"}, []string{"v", "w", "x", "y", "z"}), "entries matching no common source or not valid at the same time don't tie")
+}
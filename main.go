@@ -2,26 +2,14 @@ package main
 
 import (
 	"context"
-	"database/sql"
-	"errors"
 	"fmt"
-	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
-	"github.com/vshn/cloudscale-metrics-collector/pkg/categoriesmodel"
-	"github.com/vshn/cloudscale-metrics-collector/pkg/datetimesmodel"
-	"github.com/vshn/cloudscale-metrics-collector/pkg/discountsmodel"
-	"github.com/vshn/cloudscale-metrics-collector/pkg/factsmodel"
-	"github.com/vshn/cloudscale-metrics-collector/pkg/kubernetes"
-	"github.com/vshn/cloudscale-metrics-collector/pkg/productsmodel"
-	"github.com/vshn/cloudscale-metrics-collector/pkg/queriesmodel"
-	"github.com/vshn/cloudscale-metrics-collector/pkg/tenantsmodel"
-
-	"github.com/appuio/appuio-cloud-reporting/pkg/db"
-	"github.com/cloudscale-ch/cloudscale-go-sdk/v2"
-	"github.com/jmoiron/sqlx"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/alerting"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/tenantmapping"
 )
 
 var (
@@ -34,9 +22,43 @@ var (
 	// constants
 	daysEnvVariable            = "DAYS"
 	tokenEnvVariable           = "CLOUDSCALE_API_TOKEN"
+	accountsEnvVariable        = "CLOUDSCALE_ACCOUNTS_FILE"
 	dbUrlEnvVariable           = "ACR_DB_URL"
 	kubernetesURLEnvVariable   = "KUBERNETES_SERVER_URL"
 	kubernetesTokenEnvVariable = "KUBERNETES_SERVER_TOKEN"
+	clustersEnvVariable        = "KUBERNETES_CLUSTERS_FILE"
+	toleranceEnvVariable       = "BUCKET_COUNT_TOLERANCE"
+	catchupDaysEnvVariable     = "CATCHUP_DAYS"
+	pendingMaxDaysEnvVariable  = "PENDING_MAX_DAYS"
+	roundingEnvVariable        = "ROUNDING_POLICY"
+	outputEnvVariable          = "OUTPUT"
+	outputFileEnvVariable      = "OUTPUT_FILE"
+	webhookURLEnvVariable      = "WEBHOOK_URL"
+	alertThresholdsEnvVariable = "ALERT_THRESHOLDS_FILE"
+	alertmanagerURLEnvVariable = "ALERTMANAGER_URL"
+	pushgatewayURLEnvVariable  = "PUSHGATEWAY_URL"
+
+	tenantLabelsEnvVariable      = "TENANT_LABELS"
+	tenantAnnotationsEnvVariable = "TENANT_ANNOTATIONS"
+	namespaceLabelsEnvVariable   = "BUCKET_NAMESPACE_LABELS"
+	tenantMappingEnvVariable     = "TENANT_MAPPING_FILE"
+
+	fallbackProductEnvVariable = "FALLBACK_PRODUCT"
+	granularityEnvVariable     = "GRANULARITY"
+
+	anomalyFactorEnvVariable       = "ANOMALY_FACTOR"
+	anomalyBaselineDaysEnvVariable = "ANOMALY_BASELINE_DAYS"
+	anomalyHoldBackEnvVariable     = "ANOMALY_HOLD_BACK"
+
+	// defaultBucketCountTolerance allows the number of buckets to drop by 10% from one day to the next before a day
+	// is considered incomplete.
+	defaultBucketCountTolerance = 0.1
+	// defaultPendingMaxDays is the number of days after which an incomplete day is written anyway, see daySettled.
+	defaultPendingMaxDays = 3
+
+	// granularityDaily writes one fact per day and source, granularityHourly one per interval of the bucket metrics.
+	granularityDaily  = "daily"
+	granularityHourly = "hourly"
 
 	// source format: 'query:zone:tenant:namespace' or 'query:zone:tenant:namespace:class'
 	// We do not have real (prometheus) queries here, just random hardcoded strings.
@@ -44,33 +66,105 @@ var (
 	sourceQueryTrafficOut = "object-storage-traffic-out"
 	sourceQueryRequests   = "object-storage-requests"
 
+	// sourceUnits are the units of the raw values returned by cloudscale for each source query.
+	sourceUnits = map[string]string{
+		sourceQueryStorage:    "BDay", // the amount of bytes stored during the whole day
+		sourceQueryTrafficOut: "B",
+		sourceQueryRequests:   "Req",
+	}
+
 	// SourceZone represents the zone of the bucket, not of the cluster where the request for the bucket originated.
 	// All the zones we use here must be known to the appuio-odoo-adapter as well.
-	sourceZones = []string{"cloudscale"}
+	// Every cloudscale account has a zone of its own, see catalog.
+	defaultSourceZone = "cloudscale"
 )
 
 type config struct {
-	apiToken    string
+	accounts    []cloudscaleAccount
+	catalog     *catalog
 	databaseURL string
 
 	days int
 
+	bucketCountTolerance float64
+	catchupDays          int
+	// pendingMaxDays is the number of days after which an incomplete day is written anyway, see daySettled
+	pendingMaxDays int
+	// hourly writes a fact per interval returned by cloudscale instead of one per day, see granularityHourly
+	hourly bool
+
+	rounding *roundingPolicies
+
+	anomaly anomalyConfig
+
+	// fallbackProduct is the source of the product of sources without product, none if empty
+	fallbackProduct string
+
+	labels labelConfig
+	// tenantMapping maps buckets without a Bucket resource, nil if not configured
+	tenantMapping *tenantmapping.Mapping
+
+	// outputs are the names of the enabled sinks, see newSinks
+	outputs    []string
+	outputFile string
+	webhookURL string
+
+	alertThresholds []alerting.Threshold
+	alertmanagerURL string
+	pushgatewayURL  string
+
 	kubeconfig            string
 	kubernetesServerURL   string
 	kubernetesServerToken string
+	// kubernetesTokenFile is read again by the Kubernetes client when the token is rotated
+	kubernetesTokenFile string
+	// clusters are the clusters from the clusters file. If set, the single cluster configured above is ignored.
+	clusters []clusterConfig
 }
 
 func loadConfig() (*config, error) {
-	cloudscaleApiToken := os.Getenv(tokenEnvVariable)
-	if cloudscaleApiToken == "" {
-		return nil, fmt.Errorf("missing env var %q", tokenEnvVariable)
+	accounts, err := loadAccounts()
+	if err != nil {
+		return nil, err
 	}
 
-	dbUrl := os.Getenv(dbUrlEnvVariable)
-	if dbUrl == "" {
+	outputs, err := parseOutputs(os.Getenv(outputEnvVariable))
+	if err != nil {
+		return nil, fmt.Errorf("env var %q: %w", outputEnvVariable, err)
+	}
+
+	// the database is only needed if facts are written to it
+	dbUrl, err := getenvSecret(dbUrlEnvVariable)
+	if err != nil {
+		return nil, err
+	}
+	if dbUrl == "" && containsString(outputs, outputDB) {
 		return nil, fmt.Errorf("missing env var %q", dbUrlEnvVariable)
 	}
 
+	webhookURL := os.Getenv(webhookURLEnvVariable)
+	if webhookURL == "" && containsString(outputs, outputWebhook) {
+		return nil, fmt.Errorf("missing env var %q", webhookURLEnvVariable)
+	}
+
+	var alertThresholds []alerting.Threshold
+	if containsString(outputs, outputAlerts) {
+		path := os.Getenv(alertThresholdsEnvVariable)
+		if path == "" {
+			return nil, fmt.Errorf("missing env var %q", alertThresholdsEnvVariable)
+		}
+		alertThresholds, err = alerting.LoadThresholds(path, alertMetricNames())
+		if err != nil {
+			return nil, err
+		}
+		// the usage of the month so far is read from the database
+		for _, threshold := range alertThresholds {
+			if threshold.Period == alerting.PeriodMonth && dbUrl == "" {
+				return nil, fmt.Errorf("missing env var %q, needed for monthly thresholds", dbUrlEnvVariable)
+			}
+		}
+	}
+
 	daysStr := os.Getenv(daysEnvVariable)
 	if daysStr == "" {
 		daysStr = "1"
@@ -80,58 +174,163 @@ func loadConfig() (*config, error) {
 		return nil, fmt.Errorf("env var %q not an integer: %w", daysEnvVariable, err)
 	}
 
+	tolerance := defaultBucketCountTolerance
+	toleranceStr := os.Getenv(toleranceEnvVariable)
+	if toleranceStr != "" {
+		tolerance, err = strconv.ParseFloat(toleranceStr, 64)
+		if err != nil {
+			return nil, fmt.Errorf("env var %q not a number: %w", toleranceEnvVariable, err)
+		}
+	}
+
+	catchupDays := 0
+	catchupDaysStr := os.Getenv(catchupDaysEnvVariable)
+	if catchupDaysStr != "" {
+		catchupDays, err = strconv.Atoi(catchupDaysStr)
+		if err != nil {
+			return nil, fmt.Errorf("env var %q not an integer: %w", catchupDaysEnvVariable, err)
+		}
+	}
+	// only the database knows which days are missing, see catchUpSink
+	if catchupDays > 0 && !containsString(outputs, outputDB) {
+		return nil, fmt.Errorf("env var %q needs the %q output", catchupDaysEnvVariable, outputDB)
+	}
+
+	pendingMaxDays := defaultPendingMaxDays
+	if pendingMaxDaysStr := os.Getenv(pendingMaxDaysEnvVariable); pendingMaxDaysStr != "" {
+		pendingMaxDays, err = strconv.Atoi(pendingMaxDaysStr)
+		if err != nil {
+			return nil, fmt.Errorf("env var %q not an integer: %w", pendingMaxDaysEnvVariable, err)
+		}
+	}
+
+	var hourly bool
+	switch granularity := os.Getenv(granularityEnvVariable); granularity {
+	case "", granularityDaily:
+	case granularityHourly:
+		hourly = true
+	default:
+		return nil, fmt.Errorf("env var %q: unknown granularity %q, must be %q or %q", granularityEnvVariable, granularity, granularityDaily, granularityHourly)
+	}
+
+	rounding, err := parseRoundingPolicies(os.Getenv(roundingEnvVariable))
+	if err != nil {
+		return nil, fmt.Errorf("env var %q: %w", roundingEnvVariable, err)
+	}
+	if hourly {
+		if err := rounding.checkHourly(); err != nil {
+			return nil, fmt.Errorf("env var %q: %w", roundingEnvVariable, err)
+		}
+	}
+
+	anomaly, err := loadAnomalyConfig()
+	if err != nil {
+		return nil, err
+	}
+	anomaly.hourly = hourly
+
+	var mapping *tenantmapping.Mapping
+	if file := os.Getenv(tenantMappingEnvVariable); file != "" {
+		mapping, err = tenantmapping.Load(file)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var clusters []clusterConfig
+	if file := os.Getenv(clustersEnvVariable); file != "" {
+		clusters, err = loadClusterConfigs(file)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	kubeconfig := os.Getenv("KUBECONFIG")
 
+	// a single cluster is configured by env vars if there is no clusters file
 	url := os.Getenv(kubernetesURLEnvVariable)
-	if url == "" && kubeconfig == "" {
+	if url == "" && kubeconfig == "" && clusters == nil {
 		return nil, fmt.Errorf("missing env var %q", kubernetesURLEnvVariable)
 	}
 	token := os.Getenv(kubernetesTokenEnvVariable)
-	if token == "" && kubeconfig == "" {
+	tokenFile := os.Getenv(kubernetesTokenEnvVariable + secretFileSuffix)
+	if token == "" && tokenFile == "" && kubeconfig == "" && clusters == nil {
 		return nil, fmt.Errorf("missing env var %q", kubernetesTokenEnvVariable)
 	}
+	if token != "" && tokenFile != "" {
+		return nil, fmt.Errorf("only one of env vars %q and %q can be set", kubernetesTokenEnvVariable, kubernetesTokenEnvVariable+secretFileSuffix)
+	}
 
 	return &config{
-		apiToken:    cloudscaleApiToken,
+		accounts:    accounts,
+		catalog:     newCatalog(accountZones(accounts)),
 		databaseURL: dbUrl,
 		days:        days,
+
+		bucketCountTolerance: tolerance,
+		catchupDays:          catchupDays,
+		pendingMaxDays:       pendingMaxDays,
+		hourly:               hourly,
+
+		rounding: rounding,
+
+		anomaly: anomaly,
+
+		fallbackProduct: os.Getenv(fallbackProductEnvVariable),
+
+		labels: labelConfig{
+			tenantLabels:      parseList(os.Getenv(tenantLabelsEnvVariable), defaultTenantLabels),
+			tenantAnnotations: parseList(os.Getenv(tenantAnnotationsEnvVariable), nil),
+			namespaceLabels:   parseList(os.Getenv(namespaceLabelsEnvVariable), defaultNamespaceLabels),
+		},
+		tenantMapping: mapping,
+
+		outputs:    outputs,
+		outputFile: os.Getenv(outputFileEnvVariable),
+		webhookURL: webhookURL,
+
+		alertThresholds: alertThresholds,
+		alertmanagerURL: os.Getenv(alertmanagerURLEnvVariable),
+		pushgatewayURL:  os.Getenv(pushgatewayURLEnvVariable),
+
 		// will load KUBECONFIG if defined, otherwise will use server url and token to connect.
 		kubeconfig:            kubeconfig,
 		kubernetesServerURL:   url,
 		kubernetesServerToken: token,
+		kubernetesTokenFile:   tokenFile,
+		clusters:              clusters,
 	}, nil
 }
 
-func initDb(ctx context.Context, tx *sqlx.Tx) error {
-	for _, product := range ensureProducts {
-		_, err := productsmodel.Ensure(ctx, tx, product)
-		if err != nil {
-			return err
-		}
-	}
-
-	for _, discount := range ensureDiscounts {
-		_, err := discountsmodel.Ensure(ctx, tx, discount)
-		if err != nil {
-			return err
-		}
-	}
-
-	for _, query := range ensureQueries {
-		_, err := queriesmodel.Ensure(ctx, tx, query)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
 func main() {
 	ctx := context.Background()
 
 	fmt.Fprintf(os.Stderr, "%s: version %s (%s) compiled on %s\n", appName, version, commit, date)
 
-	err := sync(ctx)
+	command := "sync"
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+
+	var err error
+	switch command {
+	case "sync":
+		err = sync(ctx)
+	case "odoo-export":
+		err = odooExport(ctx, os.Args[2:])
+	case "report":
+		err = reportCommand(ctx, os.Args[2:])
+	case "held-facts":
+		err = heldFactsCommand(ctx, os.Args[2:])
+	case "validate":
+		err = validateCommand(ctx, os.Args[2:])
+	case "explain":
+		err = explainCommand(ctx, os.Args[2:])
+	case "lint":
+		err = lintCommand(ctx, os.Args[2:])
+	default:
+		err = fmt.Errorf("unknown command %q, must be one of: sync, validate, explain, lint, odoo-export, report, held-facts", command)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
 		os.Exit(1)
@@ -139,127 +338,101 @@ func main() {
 	os.Exit(0)
 }
 
-func sync(ctx context.Context) error {
-	cfg, err := loadConfig()
-	if err != nil {
-		return fmt.Errorf("config load: %w", err)
+// parseOutputs parses a comma separated list of outputs. Defaults to writing to the database only.
+// Only one of the file outputs (csv, jsonl) can be enabled, as they share OUTPUT_FILE.
+func parseOutputs(s string) ([]string, error) {
+	if s == "" {
+		return []string{outputDB}, nil
 	}
+	var outputs []string
+	for _, output := range strings.Split(s, ",") {
+		output = strings.TrimSpace(output)
+		switch output {
+		case outputDB, outputCSV, outputJSONL, outputWebhook, outputAlerts:
+		default:
+			return nil, fmt.Errorf("unknown output %q, must be one of %q, %q, %q, %q or %q", output, outputDB, outputCSV, outputJSONL, outputWebhook, outputAlerts)
+		}
+		if containsString(outputs, output) {
+			return nil, fmt.Errorf("output %q enabled more than once", output)
+		}
+		outputs = append(outputs, output)
+	}
+	if containsString(outputs, outputCSV) && containsString(outputs, outputJSONL) {
+		return nil, fmt.Errorf("only one of %q and %q can be enabled", outputCSV, outputJSONL)
+	}
+	return outputs, nil
+}
 
-	cloudscaleClient := cloudscale.NewClient(http.DefaultClient)
-	cloudscaleClient.AuthToken = cfg.apiToken
+// parseList parses a comma separated list, ignoring empty items. Returns def if the list is empty.
+func parseList(s string, def []string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	if len(list) == 0 {
+		return def
+	}
+	return list
+}
 
-	k8sclient, err := kubernetes.NewClient(cfg.kubeconfig, cfg.kubernetesServerURL, cfg.kubernetesServerToken)
-	if err != nil {
-		return fmt.Errorf("kubernetes client: %w", err)
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
 	}
+	return false
+}
 
-	// The cloudscale API works in Europe/Zurich, so we have to use the same, regardless of where this code runs
-	location, err := time.LoadLocation("Europe/Zurich")
+func sync(ctx context.Context) error {
+	cfg, err := loadConfig()
 	if err != nil {
-		return err
+		return fmt.Errorf("config load: %w", err)
 	}
 
-	// Fetch statistics of yesterday (as per Europe/Zurich). The metrics will cover the entire day.
-	now := time.Now().In(location)
-	date := time.Date(now.Year(), now.Month(), now.Day()-cfg.days, 0, 0, 0, 0, now.Location())
+	err = validateUnits(cfg.catalog)
 	if err != nil {
 		return err
 	}
-
-	rdb, err := db.Openx(cfg.databaseURL)
+	err = cfg.rounding.checkProducts(cfg.catalog.products, cfg.fallbackProduct)
 	if err != nil {
-		return err
+		return fmt.Errorf("env var %q: %w", roundingEnvVariable, err)
 	}
-	defer rdb.Close()
 
-	// initialize DB
-	tx, err := rdb.BeginTxx(ctx, &sql.TxOptions{})
+	accounts := newAccounts(cfg.accounts)
+
+	clusters, err := newClusters(cfg)
 	if err != nil {
 		return err
 	}
-	defer func(tx *sqlx.Tx) {
-		err := tx.Rollback()
-		if err != nil && !errors.Is(err, sql.ErrTxDone) {
-			fmt.Fprintf(os.Stderr, "rollback failed: %v", err)
-		}
-	}(tx)
-	err = initDb(ctx, tx)
+
+	// The cloudscale API works in Europe/Zurich, so we have to use the same, regardless of where this code runs
+	location, err := time.LoadLocation("Europe/Zurich")
 	if err != nil {
 		return err
 	}
-	err = tx.Commit()
+
+	// Fetch statistics of yesterday (as per Europe/Zurich). The metrics will cover the entire day.
+	now := time.Now().In(location)
+	date := time.Date(now.Year(), now.Month(), now.Day()-cfg.days, 0, 0, 0, 0, now.Location())
 	if err != nil {
 		return err
 	}
 
-	accumulated, err := accumulateBucketMetrics(ctx, date, cloudscaleClient, k8sclient)
+	sinks, err := newSinks(cfg)
 	if err != nil {
 		return err
 	}
-
-	for source, value := range accumulated {
-		if value == 0 {
-			continue
-		}
-
-		fmt.Printf("syncing %s\n", source)
-
-		// start new transaction for actual work
-		tx, err = rdb.BeginTxx(ctx, &sql.TxOptions{})
-		if err != nil {
-			return err
-		}
-
-		tenant, err := tenantsmodel.Ensure(ctx, tx, &db.Tenant{Source: source.Tenant})
-		if err != nil {
-			return err
-		}
-
-		category, err := categoriesmodel.Ensure(ctx, tx, &db.Category{Source: source.Zone + ":" + source.Namespace})
-		if err != nil {
-			return err
-		}
-
-		dateTime := datetimesmodel.New(source.Start)
-		dateTime, err = datetimesmodel.Ensure(ctx, tx, dateTime)
-		if err != nil {
-			return err
-		}
-
-		product, err := productsmodel.GetBestMatch(ctx, tx, source.String(), source.Start)
-		if err != nil {
-			return err
-		}
-
-		discount, err := discountsmodel.GetBestMatch(ctx, tx, source.String(), source.Start)
-		if err != nil {
-			return err
-		}
-
-		query, err := queriesmodel.GetByName(ctx, tx, source.Query+":"+source.Zone)
-		if err != nil {
-			return err
-		}
-
-		var quantity float64
-		if query.Unit == "GB" || query.Unit == "GBDay" {
-			quantity = float64(value) / 1000 / 1000 / 1000
-		} else if query.Unit == "KReq" {
-			quantity = float64(value) / 1000
-		} else {
-			return errors.New("Unknown query unit " + query.Unit)
-		}
-		storageFact := factsmodel.New(dateTime, query, tenant, category, product, discount, quantity)
-		_, err = factsmodel.Ensure(ctx, tx, storageFact)
-		if err != nil {
-			return err
-		}
-
-		err = tx.Commit()
-		if err != nil {
-			return err
+	err = collect(ctx, sinks, date, accounts, clusters, cfg)
+	if cfg.pushgatewayURL != "" {
+		if pushErr := pushMetrics(cfg.pushgatewayURL); pushErr != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: cannot push metrics: %v\n", pushErr)
+			if err == nil {
+				err = pushErr
+			}
 		}
 	}
-
-	return nil
+	return err
 }
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+var (
+	// metricsRegistry holds the metrics of a run. They are pushed to a Prometheus Pushgateway at the end of the run,
+	// as the collector runs as a batch job and cannot be scraped.
+	metricsRegistry = prometheus.NewRegistry()
+
+	tenantUsageGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "cloudscale_metrics_collector",
+		Name:      "tenant_usage",
+		Help:      "Usage of a tenant in the unit of the metric's query, or in CHF, for the collected day or the month up to it.",
+	}, []string{"tenant", "metric", "period"})
+
+	tenantUsageLimitGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "cloudscale_metrics_collector",
+		Name:      "tenant_usage_limit",
+		Help:      "Configured usage threshold of a tenant.",
+	}, []string{"tenant", "metric", "period"})
+
+	usageAnomalyRatioGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "cloudscale_metrics_collector",
+		Name:      "usage_anomaly_ratio",
+		Help:      "Ratio between the quantity of an anomalous fact and its baseline.",
+	}, []string{"source"})
+
+	accountUsageGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "cloudscale_metrics_collector",
+		Name:      "account_usage",
+		Help:      "Usage of all buckets of a cloudscale account on the last collected day, in the raw unit.",
+	}, []string{"query", "zone", "unit"})
+
+	attributedUsageGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "cloudscale_metrics_collector",
+		Name:      "attributed_usage",
+		Help:      "Usage attributed to tenants and written by an output on the last collected day, in the raw unit.",
+	}, []string{"query", "zone", "unit", "output"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(tenantUsageGauge, tenantUsageLimitGauge, usageAnomalyRatioGauge, accountUsageGauge, attributedUsageGauge)
+}
+
+// pushMetrics pushes all metrics to the Pushgateway at url, replacing the metrics of the previous run.
+func pushMetrics(url string) error {
+	return push.New(url, appName).Gatherer(metricsRegistry).Push()
+}
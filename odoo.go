@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/vshn/cloudscale-metrics-collector/pkg/odoo"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/units"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/usagemodel"
+)
+
+var (
+	odooURLEnvVariable      = "ODOO_URL"
+	odooDBEnvVariable       = "ODOO_DB"
+	odooLoginEnvVariable    = "ODOO_LOGIN"
+	odooPasswordEnvVariable = "ODOO_PASSWORD"
+)
+
+/*
+odooExport creates a draft Odoo invoice per tenant for the usage of a month, as collected in the database by this
+collector. The partner of the invoice is the tenant's target, the products of the lines are the products' targets.
+Partners which already have an invoice with the month as reference are skipped, so the export can be rerun. If
+ODOO_URL is not set, the JSON-RPC requests are written to stdout with the password redacted instead of being sent to
+Odoo.
+*/
+func odooExport(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("odoo-export", flag.ContinueOnError)
+	monthStr := flags.String("month", "", "month to export in the form YYYY-MM (default: previous month)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	catalog, err := loadCatalog()
+	if err != nil {
+		return err
+	}
+
+	dbUrl, err := getenvSecret(dbUrlEnvVariable)
+	if err != nil {
+		return err
+	}
+	if dbUrl == "" {
+		return fmt.Errorf("missing env var %q", dbUrlEnvVariable)
+	}
+	odooURL := os.Getenv(odooURLEnvVariable)
+	odooPassword, err := getenvSecret(odooPasswordEnvVariable)
+	if err != nil {
+		return err
+	}
+	client := odoo.NewClient(odooURL, os.Getenv(odooDBEnvVariable), os.Getenv(odooLoginEnvVariable), odooPassword)
+
+	from, err := parseMonth(*monthStr)
+	if err != nil {
+		return err
+	}
+	to := from.AddDate(0, 1, 0)
+
+	rdb, err := openDatabase(dbUrl)
+	if err != nil {
+		return err
+	}
+	defer rdb.Close()
+	tx, err := rdb.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	usage, err := usagemodel.Get(ctx, tx, from, to, catalog.queryNames())
+	if err != nil {
+		return err
+	}
+
+	invoices, err := buildInvoices(usage, from.Format("2006-01"))
+	if err != nil {
+		return err
+	}
+
+	if odooURL == "" {
+		enc := json.NewEncoder(os.Stdout)
+		for _, invoice := range invoices {
+			if err := enc.Encode(client.CreateInvoiceRequest(invoice)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	err = client.Login(ctx)
+	if err != nil {
+		return err
+	}
+	for _, invoice := range invoices {
+		existing, err := client.FindInvoice(ctx, invoice)
+		if err != nil {
+			return err
+		}
+		if existing != 0 {
+			fmt.Printf("skipping partner %d, invoice %d with ref %q exists already\n", invoice.PartnerID, existing, invoice.Ref)
+			continue
+		}
+		id, err := client.CreateInvoice(ctx, invoice)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("created invoice %d for partner %d (%d lines)\n", id, invoice.PartnerID, len(invoice.Lines))
+	}
+	return nil
+}
+
+// parseMonth returns the start of the given month (YYYY-MM) in Europe/Zurich. If s is empty, the previous month is
+// returned.
+func parseMonth(s string) (time.Time, error) {
+	location, err := time.LoadLocation("Europe/Zurich")
+	if err != nil {
+		return time.Time{}, err
+	}
+	if s == "" {
+		now := time.Now().In(location)
+		return time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, location), nil
+	}
+	month, err := time.ParseInLocation("2006-01", s, location)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid month %q: %w", s, err)
+	}
+	return month, nil
+}
+
+// buildInvoices builds one invoice per tenant with a line per namespace, query and product.
+// Tenants without a numeric target (the Odoo partner id) are skipped with a warning.
+func buildInvoices(usage []usagemodel.Usage, ref string) ([]odoo.Invoice, error) {
+	invoices := map[string]*odoo.Invoice{}
+	for _, u := range usage {
+		partnerID, err := strconv.Atoi(u.TenantTarget.String)
+		if !u.TenantTarget.Valid || err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: Cannot invoice tenant %q, target %q is not an Odoo partner id\n", u.Tenant, u.TenantTarget.String)
+			continue
+		}
+		productID, err := strconv.Atoi(u.ProductTarget.String)
+		if !u.ProductTarget.Valid || err != nil {
+			return nil, fmt.Errorf("target %q of product %q is not an Odoo product id", u.ProductTarget.String, u.Product)
+		}
+		quantity, ok := new(big.Rat).SetString(u.Quantity)
+		if !ok {
+			return nil, fmt.Errorf("invalid quantity %q of %s for tenant %q", u.Quantity, u.Query, u.Tenant)
+		}
+		if quantity.Sign() == 0 {
+			continue
+		}
+		discountPercent := new(big.Rat).Mul(units.FromFloat(u.Discount), big.NewRat(100, 1))
+
+		invoice, ok := invoices[u.Tenant]
+		if !ok {
+			invoice = &odoo.Invoice{PartnerID: partnerID, Ref: ref}
+			invoices[u.Tenant] = invoice
+		}
+		invoice.Lines = append(invoice.Lines, odoo.InvoiceLine{
+			ProductID: productID,
+			Name:      u.QueryDescription + " - " + u.Namespace(),
+			Quantity:  json.Number(units.FormatDecimal(quantity)),
+			PriceUnit: json.Number(units.FormatDecimal(units.FromFloat(u.Amount))),
+			Discount:  json.Number(units.FormatDecimal(discountPercent)),
+		})
+	}
+
+	tenants := make([]string, 0, len(invoices))
+	for tenant := range invoices {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+	result := make([]odoo.Invoice, 0, len(tenants))
+	for _, tenant := range tenants {
+		result = append(result, *invoices[tenant])
+	}
+	return result, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/usagemodel"
+)
+
+func TestBuildInvoices(t *testing.T) {
+	usage := []usagemodel.Usage{
+		{
+			Tenant: "inity", TenantTarget: sql.NullString{String: "12", Valid: true}, Category: "cloudscale:testnamespace",
+			Query: "object-storage-storage:cloudscale", QueryDescription: "Object Storage - Storage (cloudscale.ch)",
+			Product: "object-storage-storage:cloudscale", ProductTarget: sql.NullString{String: "1401", Valid: true},
+			Amount: 0.0033, Discount: 0.15, Quantity: "45.000000001",
+		},
+		{
+			Tenant: "inity", TenantTarget: sql.NullString{String: "12", Valid: true}, Category: "cloudscale:testnamespace",
+			Query: "object-storage-requests:cloudscale", QueryDescription: "Object Storage - Requests (cloudscale.ch)",
+			Product: "object-storage-requests:cloudscale", ProductTarget: sql.NullString{String: "1405", Valid: true},
+			Amount: 0.0055, Quantity: "0",
+		},
+		{
+			Tenant: "unknown", Category: "cloudscale:other",
+			Query: "object-storage-storage:cloudscale", QueryDescription: "Object Storage - Storage (cloudscale.ch)",
+			Product: "object-storage-storage:cloudscale", ProductTarget: sql.NullString{String: "1401", Valid: true},
+			Amount: 0.0033, Quantity: "1",
+		},
+	}
+
+	invoices, err := buildInvoices(usage, "2022-10")
+	require.NoError(t, err)
+	require.Len(t, invoices, 1, "tenant without target must be skipped")
+	assert.Equal(t, 12, invoices[0].PartnerID)
+	assert.Equal(t, "2022-10", invoices[0].Ref)
+	require.Len(t, invoices[0].Lines, 1, "lines without quantity must be skipped")
+	line := invoices[0].Lines[0]
+	assert.Equal(t, 1401, line.ProductID)
+	assert.Equal(t, "Object Storage - Storage (cloudscale.ch) - testnamespace", line.Name)
+	assert.Equal(t, "45.000000001", line.Quantity.String())
+	assert.Equal(t, "0.0033", line.PriceUnit.String())
+	assert.Equal(t, "15", line.Discount.String())
+
+	usage[0].ProductTarget = sql.NullString{String: "storage", Valid: true}
+	_, err = buildInvoices(usage, "2022-10")
+	assert.Error(t, err)
+}
@@ -0,0 +1,194 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	// PeriodDay compares the threshold to the usage of the collected day.
+	PeriodDay = "day"
+	// PeriodMonth compares the threshold to the usage of the month up to and including the collected day.
+	PeriodMonth = "month"
+
+	// AllTenants is used as tenant of a threshold applying to every tenant.
+	AllTenants = "*"
+)
+
+// Threshold is a usage limit of a tenant. Metric is one of the metrics the thresholds are evaluated against, e.g.
+// "storage" or "chf".
+type Threshold struct {
+	Tenant string      `json:"tenant"`
+	Metric string      `json:"metric"`
+	Period string      `json:"period"`
+	Limit  json.Number `json:"limit"`
+}
+
+// LoadThresholds reads the thresholds from a JSON file containing a list of thresholds, and validates them against
+// the known metrics.
+func LoadThresholds(path string, metrics []string) ([]Threshold, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open thresholds file: %w", err)
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	dec.UseNumber()
+	var thresholds []Threshold
+	if err := dec.Decode(&thresholds); err != nil {
+		return nil, fmt.Errorf("cannot parse thresholds file %s: %w", path, err)
+	}
+	for i, threshold := range thresholds {
+		if err := threshold.validate(metrics); err != nil {
+			return nil, fmt.Errorf("threshold %d in %s: %w", i, path, err)
+		}
+	}
+	return thresholds, nil
+}
+
+func (t Threshold) validate(metrics []string) error {
+	if t.Tenant == "" {
+		return fmt.Errorf("tenant must be set, use %q for all tenants", AllTenants)
+	}
+	known := false
+	for _, metric := range metrics {
+		known = known || metric == t.Metric
+	}
+	if !known {
+		return fmt.Errorf("unknown metric %q, must be one of %v", t.Metric, metrics)
+	}
+	if t.Period != PeriodDay && t.Period != PeriodMonth {
+		return fmt.Errorf("unknown period %q, must be %q or %q", t.Period, PeriodDay, PeriodMonth)
+	}
+	limit, ok := t.limit()
+	if !ok || limit.Sign() <= 0 {
+		return fmt.Errorf("limit %q must be a positive number", t.Limit)
+	}
+	return nil
+}
+
+func (t Threshold) limit() (*big.Rat, bool) {
+	return new(big.Rat).SetString(t.Limit.String())
+}
+
+// Usage maps tenants to their usage per metric.
+type Usage map[string]map[string]*big.Rat
+
+// Add adds the value to the usage of the tenant's metric.
+func (u Usage) Add(tenant, metric string, value *big.Rat) {
+	if u[tenant] == nil {
+		u[tenant] = map[string]*big.Rat{}
+	}
+	if u[tenant][metric] == nil {
+		u[tenant][metric] = new(big.Rat)
+	}
+	u[tenant][metric].Add(u[tenant][metric], value)
+}
+
+// Alert is a threshold exceeded by a tenant.
+type Alert struct {
+	Tenant    string
+	Metric    string
+	Period    string
+	Value     *big.Rat
+	Limit     *big.Rat
+	Threshold Threshold
+}
+
+func (a Alert) String() string {
+	return fmt.Sprintf("tenant %q exceeded the %s %s threshold: %s > %s", a.Tenant, a.Period, a.Metric, a.Value.FloatString(2), a.Limit.FloatString(2))
+}
+
+// Evaluate returns an alert for every threshold of the given period exceeded by the usage, ordered by tenant and
+// metric. Thresholds for AllTenants are evaluated against every tenant.
+func Evaluate(thresholds []Threshold, period string, usage Usage) []Alert {
+	var alerts []Alert
+	for _, threshold := range thresholds {
+		if threshold.Period != period {
+			continue
+		}
+		limit, _ := threshold.limit()
+		for tenant, metrics := range usage {
+			if threshold.Tenant != AllTenants && threshold.Tenant != tenant {
+				continue
+			}
+			value, ok := metrics[threshold.Metric]
+			if !ok || value.Cmp(limit) <= 0 {
+				continue
+			}
+			alerts = append(alerts, Alert{Tenant: tenant, Metric: threshold.Metric, Period: period, Value: value, Limit: limit, Threshold: threshold})
+		}
+	}
+	sort.SliceStable(alerts, func(i, j int) bool {
+		if alerts[i].Tenant != alerts[j].Tenant {
+			return alerts[i].Tenant < alerts[j].Tenant
+		}
+		return alerts[i].Metric < alerts[j].Metric
+	})
+	return alerts
+}
+
+// alertmanagerAlert is an alert as accepted by the Alertmanager API v2 (POST /api/v2/alerts).
+type alertmanagerAlert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+}
+
+// Send posts the alerts to an Alertmanager compatible endpoint (e.g. "http://alertmanager:9093/api/v2/alerts").
+// The alerts resolve after the given duration unless they are sent again.
+func Send(ctx context.Context, client *http.Client, url string, alerts []Alert, resolveAfter time.Duration) error {
+	if len(alerts) == 0 {
+		return nil
+	}
+	now := time.Now()
+	payload := make([]alertmanagerAlert, 0, len(alerts))
+	for _, alert := range alerts {
+		payload = append(payload, alertmanagerAlert{
+			Labels: map[string]string{
+				"alertname": "CloudscaleUsageThresholdExceeded",
+				"severity":  "warning",
+				"tenant":    alert.Tenant,
+				"metric":    alert.Metric,
+				"period":    alert.Period,
+			},
+			Annotations: map[string]string{
+				"summary":     fmt.Sprintf("Tenant %s exceeded its %s %s threshold", alert.Tenant, alert.Period, alert.Metric),
+				"description": alert.String(),
+				"value":       alert.Value.FloatString(2),
+				"limit":       alert.Limit.FloatString(2),
+			},
+			StartsAt: now,
+			EndsAt:   now.Add(resolveAfter),
+		})
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("alertmanager returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var metrics = []string{"storage", "chf"}
+
+func writeThresholds(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "thresholds.json")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadThresholds(t *testing.T) {
+	thresholds, err := LoadThresholds(writeThresholds(t, `[
+  {"tenant": "*", "metric": "chf", "period": "month", "limit": 100},
+  {"tenant": "inity", "metric": "storage", "period": "day", "limit": 1.5}
+]`), metrics)
+	require.NoError(t, err)
+	require.Len(t, thresholds, 2)
+	assert.Equal(t, "1.5", thresholds[1].Limit.String())
+
+	for _, invalid := range []string{
+		`[{"tenant": "", "metric": "chf", "period": "month", "limit": 100}]`,
+		`[{"tenant": "*", "metric": "cpu", "period": "month", "limit": 100}]`,
+		`[{"tenant": "*", "metric": "chf", "period": "year", "limit": 100}]`,
+		`[{"tenant": "*", "metric": "chf", "period": "month", "limit": -1}]`,
+		`[{"tenant": "*", "metric": "chf", "period": "month", "limit": 0}]`,
+		`[{"tenant": "*", "metric": "chf", "period": "month", "limit": 1, "unknown": true}]`,
+		`{}`,
+	} {
+		_, err := LoadThresholds(writeThresholds(t, invalid), metrics)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	thresholds := []Threshold{
+		{Tenant: AllTenants, Metric: "chf", Period: PeriodMonth, Limit: "100"},
+		{Tenant: "inity", Metric: "storage", Period: PeriodDay, Limit: "1.5"},
+	}
+	usage := Usage{}
+	usage.Add("inity", "storage", big.NewRat(1, 1))
+	usage.Add("inity", "storage", big.NewRat(1, 1))
+	usage.Add("inity", "chf", big.NewRat(100, 1))
+	usage.Add("vshn", "storage", big.NewRat(10, 1))
+	usage.Add("vshn", "chf", big.NewRat(101, 1))
+
+	alerts := Evaluate(thresholds, PeriodDay, usage)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, "inity", alerts[0].Tenant)
+	assert.Equal(t, "storage", alerts[0].Metric)
+	assert.Equal(t, `tenant "inity" exceeded the day storage threshold: 2.00 > 1.50`, alerts[0].String())
+
+	alerts = Evaluate(thresholds, PeriodMonth, usage)
+	require.Len(t, alerts, 1, "a limit reached exactly is no alert")
+	assert.Equal(t, "vshn", alerts[0].Tenant)
+}
+
+func TestSend(t *testing.T) {
+	var received []alertmanagerAlert
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+	}))
+	defer server.Close()
+
+	alert := Alert{Tenant: "vshn", Metric: "chf", Period: PeriodMonth, Value: big.NewRat(101, 1), Limit: big.NewRat(100, 1)}
+	require.NoError(t, Send(context.Background(), http.DefaultClient, server.URL, []Alert{alert}, time.Hour))
+	require.Len(t, received, 1)
+	assert.Equal(t, "vshn", received[0].Labels["tenant"])
+	assert.Equal(t, "101.00", received[0].Annotations["value"])
+	assert.Equal(t, time.Hour, received[0].EndsAt.Sub(received[0].StartsAt))
+}
@@ -0,0 +1,54 @@
+package anomaly
+
+import (
+	"math"
+	"sort"
+)
+
+// Detector flags values deviating from a baseline, the median of the previous values.
+type Detector struct {
+	// Factor is the maximum ratio between a value and the baseline in both directions, e.g. 10 flags values more than
+	// 10 times larger or smaller than the baseline.
+	Factor float64
+	// MinSamples is the number of previous values needed for a baseline. Values with a shorter history are never flagged.
+	MinSamples int
+}
+
+// Result is the outcome of checking a value.
+type Result struct {
+	Baseline float64
+	// Ratio is the value divided by the baseline. It is 0 if there is no baseline.
+	Ratio     float64
+	Anomalous bool
+}
+
+// Check compares the value to the median of the history.
+func (d Detector) Check(value float64, history []float64) Result {
+	if len(history) == 0 || len(history) < d.MinSamples {
+		return Result{}
+	}
+	baseline := Median(history)
+	if baseline <= 0 {
+		return Result{Baseline: baseline}
+	}
+	ratio := value / baseline
+	return Result{
+		Baseline:  baseline,
+		Ratio:     ratio,
+		Anomalous: ratio > d.Factor || ratio < 1/d.Factor,
+	}
+}
+
+// Median returns the median of the values, or NaN if there are none. The values are not modified.
+func Median(values []float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		return sorted[middle]
+	}
+	return (sorted[middle-1] + sorted[middle]) / 2
+}
@@ -0,0 +1,36 @@
+package anomaly
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMedian(t *testing.T) {
+	assert.True(t, math.IsNaN(Median(nil)))
+	assert.Equal(t, 3.0, Median([]float64{5, 1, 3}))
+	assert.Equal(t, 2.5, Median([]float64{4, 1, 3, 2}))
+
+	values := []float64{3, 1, 2}
+	Median(values)
+	assert.Equal(t, []float64{3, 1, 2}, values, "values must not be sorted in place")
+}
+
+func TestDetectorCheck(t *testing.T) {
+	d := Detector{Factor: 10, MinSamples: 3}
+	history := []float64{1, 2, 2, 3, 100}
+
+	r := d.Check(20, history)
+	assert.Equal(t, Result{Baseline: 2, Ratio: 10}, r, "the factor itself is not anomalous")
+
+	r = d.Check(100, history)
+	assert.True(t, r.Anomalous)
+	assert.Equal(t, 50.0, r.Ratio)
+
+	r = d.Check(0.1, history)
+	assert.True(t, r.Anomalous, "drops are anomalous as well")
+
+	assert.False(t, d.Check(100, []float64{1, 1}).Anomalous, "not enough samples")
+	assert.False(t, d.Check(100, []float64{0, 0, 0}).Anomalous, "no baseline")
+}
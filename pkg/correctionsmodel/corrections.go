@@ -0,0 +1,82 @@
+package correctionsmodel
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+	"github.com/jmoiron/sqlx"
+)
+
+// Correction records a change of an existing fact's quantity by a collector run.
+type Correction struct {
+	Id string
+
+	FactId string `db:"fact_id"`
+	RunId  string `db:"run_id"`
+
+	// Source is the full source string ("query:zone:tenant:namespace") of the corrected fact.
+	Source    string
+	Timestamp time.Time
+
+	OldQuantity float64 `db:"old_quantity"`
+	NewQuantity float64 `db:"new_quantity"`
+
+	CorrectedAt time.Time `db:"corrected_at"`
+}
+
+// Delta returns the difference between the new and the old quantity.
+func (c Correction) Delta() float64 {
+	return c.NewQuantity - c.OldQuantity
+}
+
+// EnsureTable creates the fact_corrections table if it doesn't exist yet, and adds the reference to the run to tables
+// created without it. The collector_runs table must exist already, see runsmodel.EnsureTables.
+// The table is not part of the appuio-cloud-reporting schema, it is owned by this collector.
+func EnsureTable(ctx context.Context, tx *sqlx.Tx) error {
+	_, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS fact_corrections (
+  id            uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  fact_id       uuid NOT NULL,
+  run_id        uuid NOT NULL,
+  source        text NOT NULL,
+  timestamp     timestamp with time zone NOT NULL,
+  old_quantity  double precision NOT NULL,
+  new_quantity  double precision NOT NULL,
+  corrected_at  timestamp with time zone NOT NULL DEFAULT now(),
+
+  CONSTRAINT fk_fact
+    FOREIGN KEY(fact_id)
+    REFERENCES facts(id),
+
+  CONSTRAINT fk_run
+    FOREIGN KEY(run_id)
+    REFERENCES collector_runs(id)
+)`)
+	if err != nil {
+		return fmt.Errorf("cannot create table fact_corrections: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `DO $$
+BEGIN
+  IF NOT EXISTS (SELECT FROM pg_constraint WHERE conname = 'fk_run' AND conrelid = 'fact_corrections'::regclass) THEN
+    ALTER TABLE fact_corrections
+      ADD CONSTRAINT fk_run
+        FOREIGN KEY(run_id)
+        REFERENCES collector_runs(id);
+  END IF;
+END $$`)
+	if err != nil {
+		return fmt.Errorf("cannot add run reference to fact_corrections: %w", err)
+	}
+	return nil
+}
+
+func Create(p db.NamedPreparer, in *Correction) (*Correction, error) {
+	var correction Correction
+	err := db.GetNamed(p, &correction,
+		"INSERT INTO fact_corrections (fact_id, run_id, source, timestamp, old_quantity, new_quantity) VALUES (:fact_id, :run_id, :source, :timestamp, :old_quantity, :new_quantity) RETURNING *", in)
+	if err != nil {
+		err = fmt.Errorf("cannot create fact correction %v: %w", in, err)
+	}
+	return &correction, err
+}
@@ -22,6 +22,16 @@ func GetBySource(ctx context.Context, tx *sqlx.Tx, source string) (*db.Discount,
 	return &discounts[0], nil
 }
 
+// GetAll returns all discounts ordered by source and validity.
+func GetAll(ctx context.Context, tx *sqlx.Tx) ([]db.Discount, error) {
+	var discounts []db.Discount
+	err := sqlx.SelectContext(ctx, tx, &discounts, `SELECT discounts.* FROM discounts ORDER BY source, during`)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get discounts: %w", err)
+	}
+	return discounts, nil
+}
+
 func getBySourceQueryAndTime(ctx context.Context, tx *sqlx.Tx, sourceQuery string, timestamp time.Time) ([]db.Discount, error) {
 	var discounts []db.Discount
 	err := sqlx.SelectContext(ctx, tx, &discounts,
@@ -35,6 +45,12 @@ func getBySourceQueryAndTime(ctx context.Context, tx *sqlx.Tx, sourceQuery strin
 	return discounts, nil
 }
 
+// GetCandidates returns all discounts valid at the given time whose source has the same query as the given source.
+// GetBestMatch picks the best match among them.
+func GetCandidates(ctx context.Context, tx *sqlx.Tx, source string, timestamp time.Time) ([]db.Discount, error) {
+	return getBySourceQueryAndTime(ctx, tx, tokenmatcher.NewTokenizedSource(source).Tokens[0], timestamp)
+}
+
 func GetBestMatch(ctx context.Context, tx *sqlx.Tx, source string, timestamp time.Time) (*db.Discount, error) {
 	tokenizedSource := tokenmatcher.NewTokenizedSource(source)
 	candidateDiscounts, err := getBySourceQueryAndTime(ctx, tx, tokenizedSource.Tokens[0], timestamp)
@@ -48,6 +64,9 @@ func GetBestMatch(ctx context.Context, tx *sqlx.Tx, source string, timestamp tim
 	}
 
 	match := tokenmatcher.FindBestMatch(tokenizedSource, candidateSourcePatterns)
+	if match == nil {
+		return nil, nil
+	}
 
 	for _, candidateDiscount := range candidateDiscounts {
 		if candidateDiscount.Source == match.String() {
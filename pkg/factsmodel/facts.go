@@ -6,8 +6,26 @@ import (
 	"github.com/appuio/appuio-cloud-reporting/pkg/db"
 	"github.com/jmoiron/sqlx"
 	"reflect"
+	"strings"
+	"time"
 )
 
+// History is the quantity of a fact together with the names of its query, tenant and category.
+type History struct {
+	Query     string    `db:"query"`
+	Tenant    string    `db:"tenant"`
+	Category  string    `db:"category"`
+	Timestamp time.Time `db:"timestamp"`
+	Quantity  float64   `db:"quantity"`
+}
+
+// Source returns the source string ("query:zone:tenant:namespace") of the fact. The query name is expected to be in the
+// form "query:zone" and the category in the form "zone:namespace".
+func (h History) Source() string {
+	_, namespace, _ := strings.Cut(h.Category, ":")
+	return h.Query + ":" + h.Tenant + ":" + namespace
+}
+
 func GetByFact(ctx context.Context, tx *sqlx.Tx, fact *db.Fact) (*db.Fact, error) {
 	var facts []db.Fact
 	err := sqlx.SelectContext(ctx, tx, &facts,
@@ -22,6 +40,56 @@ func GetByFact(ctx context.Context, tx *sqlx.Tx, fact *db.Fact) (*db.Fact, error
 	return &facts[0], nil
 }
 
+// CountByTimeRangeAndQueries returns the number of facts in the time range [from, to) belonging to one of the given
+// queries.
+func CountByTimeRangeAndQueries(ctx context.Context, tx *sqlx.Tx, from, to time.Time, queryIds []string) (int, error) {
+	if len(queryIds) == 0 {
+		return 0, nil
+	}
+	query, args, err := sqlx.In(`SELECT COUNT(*) FROM facts
+                  INNER JOIN date_times ON facts.date_time_id = date_times.id
+                  WHERE date_times.timestamp >= ? AND date_times.timestamp < ?
+                  AND facts.query_id IN (?)`,
+		from, to, queryIds)
+	if err != nil {
+		return 0, fmt.Errorf("cannot build count query: %w", err)
+	}
+	var count int
+	err = sqlx.GetContext(ctx, tx, &count, tx.Rebind(query), args...)
+	if err != nil {
+		return 0, fmt.Errorf("cannot count facts from %s to %s: %w", from, to, err)
+	}
+	return count, nil
+}
+
+// GetHistory returns the facts of the given queries in the time range [from, to), ordered by timestamp.
+func GetHistory(ctx context.Context, tx *sqlx.Tx, from, to time.Time, queryNames []string) ([]History, error) {
+	if len(queryNames) == 0 {
+		return nil, nil
+	}
+	query, args, err := sqlx.In(`SELECT
+                  queries.name AS query, tenants.source AS tenant, categories.source AS category,
+                  date_times.timestamp AS timestamp, facts.quantity AS quantity
+                  FROM facts
+                  INNER JOIN date_times ON facts.date_time_id = date_times.id
+                  INNER JOIN tenants ON facts.tenant_id = tenants.id
+                  INNER JOIN categories ON facts.category_id = categories.id
+                  INNER JOIN queries ON facts.query_id = queries.id
+                  WHERE date_times.timestamp >= ? AND date_times.timestamp < ?
+                  AND queries.name IN (?)
+                  ORDER BY date_times.timestamp`,
+		from, to, queryNames)
+	if err != nil {
+		return nil, fmt.Errorf("cannot build history query: %w", err)
+	}
+	var history []History
+	err = sqlx.SelectContext(ctx, tx, &history, tx.Rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get facts from %s to %s: %w", from, to, err)
+	}
+	return history, nil
+}
+
 func Ensure(ctx context.Context, tx *sqlx.Tx, ensureFact *db.Fact) (*db.Fact, error) {
 	fact, err := GetByFact(ctx, tx, ensureFact)
 	if err != nil {
@@ -65,7 +133,24 @@ func Update(p db.NamedPreparer, in *db.Fact) error {
 	return err
 }
 
-func New(dateTime *db.DateTime, query *db.Query, tenant *db.Tenant, category *db.Category, product *db.Product, discount *db.Discount, quanity float64) *db.Fact {
+// New returns the fact of the given quantity. All the references are required, as facts reference them by id.
+func New(dateTime *db.DateTime, query *db.Query, tenant *db.Tenant, category *db.Category, product *db.Product, discount *db.Discount, quanity float64) (*db.Fact, error) {
+	references := []struct {
+		name  string
+		isNil bool
+	}{
+		{"date time", dateTime == nil},
+		{"query", query == nil},
+		{"tenant", tenant == nil},
+		{"category", category == nil},
+		{"product", product == nil},
+		{"discount", discount == nil},
+	}
+	for _, r := range references {
+		if r.isNil {
+			return nil, fmt.Errorf("cannot create fact without %s", r.name)
+		}
+	}
 	return &db.Fact{
 		DateTimeId: dateTime.Id,
 		QueryId:    query.Id,
@@ -74,5 +159,5 @@ func New(dateTime *db.DateTime, query *db.Query, tenant *db.Tenant, category *db
 		ProductId:  product.Id,
 		DiscountId: discount.Id,
 		Quantity:   quanity,
-	}
+	}, nil
 }
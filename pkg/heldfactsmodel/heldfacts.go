@@ -0,0 +1,133 @@
+package heldfactsmodel
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	StatusHeld     = "held"
+	StatusApproved = "approved"
+	StatusRejected = "rejected"
+)
+
+// HeldFact is a fact which has not been written because its quantity was anomalous. It is written once approved.
+type HeldFact struct {
+	Id    string
+	RunId string `db:"run_id"`
+
+	Query     string
+	Zone      string
+	Tenant    string
+	Namespace string
+	Timestamp time.Time
+
+	// Value is the raw value as returned by cloudscale, Quantity the converted value the fact would have.
+	Value    int64
+	Quantity float64
+	// Baseline is the quantity the fact has been compared to.
+	Baseline float64
+
+	Status    string
+	CreatedAt time.Time    `db:"created_at"`
+	DecidedAt sql.NullTime `db:"decided_at"`
+}
+
+// Source returns the full source string ("query:zone:tenant:namespace") of the held fact.
+func (f HeldFact) Source() string {
+	return f.Query + ":" + f.Zone + ":" + f.Tenant + ":" + f.Namespace
+}
+
+// EnsureTable creates the collector_held_facts table if it doesn't exist yet.
+// The table is not part of the appuio-cloud-reporting schema, it is owned by this collector.
+func EnsureTable(ctx context.Context, tx *sqlx.Tx) error {
+	_, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS collector_held_facts (
+  id          uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  run_id      uuid NOT NULL,
+  query       text NOT NULL,
+  zone        text NOT NULL,
+  tenant      text NOT NULL,
+  namespace   text NOT NULL,
+  timestamp   timestamp with time zone NOT NULL,
+  value       bigint NOT NULL,
+  quantity    double precision NOT NULL,
+  baseline    double precision NOT NULL,
+  status      text NOT NULL,
+  created_at  timestamp with time zone NOT NULL DEFAULT now(),
+  decided_at  timestamp with time zone,
+
+  UNIQUE (query, zone, tenant, namespace, timestamp)
+)`)
+	if err != nil {
+		return fmt.Errorf("cannot create table collector_held_facts: %w", err)
+	}
+	return nil
+}
+
+// GetBySource returns the held fact of the given source at the given timestamp, or nil if there is none.
+func GetBySource(ctx context.Context, tx *sqlx.Tx, query, zone, tenant, namespace string, timestamp time.Time) (*HeldFact, error) {
+	var heldFacts []HeldFact
+	err := sqlx.SelectContext(ctx, tx, &heldFacts,
+		`SELECT * FROM collector_held_facts WHERE query = $1 AND zone = $2 AND tenant = $3 AND namespace = $4 AND timestamp = $5`,
+		query, zone, tenant, namespace, timestamp)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get held fact by source %s:%s:%s:%s: %w", query, zone, tenant, namespace, err)
+	}
+	if len(heldFacts) == 0 {
+		return nil, nil
+	}
+	return &heldFacts[0], nil
+}
+
+// GetById returns the held fact with the given id, or nil if there is none.
+func GetById(ctx context.Context, tx *sqlx.Tx, id string) (*HeldFact, error) {
+	var heldFacts []HeldFact
+	err := sqlx.SelectContext(ctx, tx, &heldFacts, `SELECT * FROM collector_held_facts WHERE id = $1`, id)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get held fact %s: %w", id, err)
+	}
+	if len(heldFacts) == 0 {
+		return nil, nil
+	}
+	return &heldFacts[0], nil
+}
+
+// GetByStatus returns all held facts with the given status, ordered by timestamp.
+func GetByStatus(ctx context.Context, tx *sqlx.Tx, status string) ([]HeldFact, error) {
+	var heldFacts []HeldFact
+	err := sqlx.SelectContext(ctx, tx, &heldFacts,
+		`SELECT * FROM collector_held_facts WHERE status = $1 ORDER BY timestamp, query, zone, tenant, namespace`, status)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get held facts by status %s: %w", status, err)
+	}
+	return heldFacts, nil
+}
+
+// Hold inserts the held fact. If the fact is already held, its values are replaced by the new ones.
+func Hold(p db.NamedPreparer, in *HeldFact) (*HeldFact, error) {
+	var heldFact HeldFact
+	err := db.GetNamed(p, &heldFact,
+		`INSERT INTO collector_held_facts (run_id, query, zone, tenant, namespace, timestamp, value, quantity, baseline, status)
+                  VALUES (:run_id, :query, :zone, :tenant, :namespace, :timestamp, :value, :quantity, :baseline, 'held')
+                  ON CONFLICT (query, zone, tenant, namespace, timestamp) DO UPDATE SET
+                  run_id = EXCLUDED.run_id, value = EXCLUDED.value, quantity = EXCLUDED.quantity, baseline = EXCLUDED.baseline
+                  RETURNING *`, in)
+	if err != nil {
+		err = fmt.Errorf("cannot hold fact %v: %w", in, err)
+	}
+	return &heldFact, err
+}
+
+// Decide sets the status of the held fact to approved or rejected.
+func Decide(ctx context.Context, tx *sqlx.Tx, id, status string) error {
+	_, err := tx.ExecContext(ctx, `UPDATE collector_held_facts SET status = $1, decided_at = now() WHERE id = $2`, status, id)
+	if err != nil {
+		return fmt.Errorf("cannot set status of held fact %s to %s: %w", id, status, err)
+	}
+	return nil
+}
@@ -12,16 +12,10 @@ import (
 )
 
 // NewClient creates a k8s client from the server url and token url
-func NewClient(kubeconfig, url, token string) (client.Client, error) {
-	scheme := runtime.NewScheme()
-	if err := corev1.AddToScheme(scheme); err != nil {
-		return nil, fmt.Errorf("core scheme: %w", err)
-	}
-	if err := apis.AddToScheme(scheme); err != nil {
-		return nil, fmt.Errorf("cannot add k8s exoscale scheme: %w", err)
-	}
-
-	config := &rest.Config{Host: url, BearerToken: token}
+// Instead of the token, a file containing the token can be given. The file is read again periodically, so rotated
+// tokens are picked up.
+func NewClient(kubeconfig, url, token, tokenFile string) (client.Client, error) {
+	config := &rest.Config{Host: url, BearerToken: token, BearerTokenFile: tokenFile}
 
 	// kubeconfig takes precedence if set.
 	if kubeconfig != "" {
@@ -32,6 +26,30 @@ func NewClient(kubeconfig, url, token string) (client.Client, error) {
 		}
 		config = c
 	}
+	return newClient(config)
+}
+
+// NewClientForContext creates a k8s client for the given context of the kubeconfig. If context is empty, the current
+// context is used.
+func NewClientForContext(kubeconfig, context string) (client.Client, error) {
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfig},
+		&clientcmd.ConfigOverrides{CurrentContext: context},
+	).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("unable to load context %q from kubeconfig: %w", context, err)
+	}
+	return newClient(config)
+}
+
+func newClient(config *rest.Config) (client.Client, error) {
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		return nil, fmt.Errorf("core scheme: %w", err)
+	}
+	if err := apis.AddToScheme(scheme); err != nil {
+		return nil, fmt.Errorf("cannot add k8s exoscale scheme: %w", err)
+	}
 
 	c, err := client.New(config, client.Options{
 		Scheme: scheme,
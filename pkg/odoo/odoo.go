@@ -0,0 +1,214 @@
+package odoo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Request is a JSON-RPC request as accepted by the /jsonrpc endpoint of Odoo.
+type Request struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  Params `json:"params"`
+	ID      int    `json:"id"`
+}
+
+// Params are the parameters of a JSON-RPC call to an Odoo service.
+type Params struct {
+	Service string        `json:"service"`
+	Method  string        `json:"method"`
+	Args    []interface{} `json:"args"`
+}
+
+type response struct {
+	Result json.RawMessage `json:"result"`
+	Error  *Error          `json:"error"`
+}
+
+// Error is the error returned by Odoo.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    struct {
+		Name    string `json:"name"`
+		Message string `json:"message"`
+	} `json:"data"`
+}
+
+func (e *Error) Error() string {
+	if e.Data.Message != "" {
+		return fmt.Sprintf("odoo error %d: %s: %s", e.Code, e.Message, e.Data.Message)
+	}
+	return fmt.Sprintf("odoo error %d: %s", e.Code, e.Message)
+}
+
+// InvoiceLine is a line of a customer invoice. Quantity, PriceUnit and Discount are decimal numbers, the discount is
+// in percent.
+type InvoiceLine struct {
+	ProductID int
+	Name      string
+	Quantity  json.Number
+	PriceUnit json.Number
+	Discount  json.Number
+}
+
+// Invoice is a draft customer invoice.
+type Invoice struct {
+	PartnerID int
+	// Ref is the reference of the invoice, e.g. the billing period.
+	Ref   string
+	Lines []InvoiceLine
+}
+
+// Values returns the values to create the invoice as an `account.move` record.
+// The lines are created in the same call using the "create" command (0, 0, values) of the one2many field.
+func (inv Invoice) Values() map[string]interface{} {
+	lines := make([]interface{}, 0, len(inv.Lines))
+	for _, line := range inv.Lines {
+		lines = append(lines, []interface{}{0, 0, map[string]interface{}{
+			"product_id": line.ProductID,
+			"name":       line.Name,
+			"quantity":   line.Quantity,
+			"price_unit": line.PriceUnit,
+			"discount":   line.Discount,
+		}})
+	}
+	return map[string]interface{}{
+		"move_type":        "out_invoice",
+		"partner_id":       inv.PartnerID,
+		"ref":              inv.Ref,
+		"invoice_line_ids": lines,
+	}
+}
+
+// Client is a minimal Odoo JSON-RPC client.
+type Client struct {
+	url      string
+	database string
+	login    string
+	password string
+
+	http *http.Client
+	uid  int
+	id   int
+}
+
+// NewClient returns a client for the Odoo instance at the given URL (e.g. "https://odoo.example.com").
+func NewClient(url, database, login, password string) *Client {
+	return &Client{
+		url:      strings.TrimSuffix(url, "/") + "/jsonrpc",
+		database: database,
+		login:    login,
+		password: password,
+		http:     http.DefaultClient,
+	}
+}
+
+// Login authenticates the client. It must be called before any other call.
+func (c *Client) Login(ctx context.Context) error {
+	var uid interface{}
+	err := c.call(ctx, "common", "login", []interface{}{c.database, c.login, c.password}, &uid)
+	if err != nil {
+		return fmt.Errorf("cannot login to odoo: %w", err)
+	}
+	// Odoo returns false if the credentials are wrong
+	id, ok := uid.(float64)
+	if !ok || id == 0 {
+		return fmt.Errorf("cannot login to odoo as %q: invalid credentials", c.login)
+	}
+	c.uid = int(id)
+	return nil
+}
+
+// redacted replaces the password in requests which are only printed.
+const redacted = "REDACTED"
+
+// CreateInvoiceRequest returns the JSON-RPC request creating the invoice, e.g. to print it. The password is redacted.
+func (c *Client) CreateInvoiceRequest(inv Invoice) Request {
+	return c.createInvoiceRequest(inv, redacted)
+}
+
+func (c *Client) createInvoiceRequest(inv Invoice, password string) Request {
+	return c.newRequest("object", "execute_kw", []interface{}{
+		c.database, c.uid, password, "account.move", "create", []interface{}{inv.Values()},
+	})
+}
+
+// CreateInvoice creates the invoice and returns its id.
+func (c *Client) CreateInvoice(ctx context.Context, inv Invoice) (int, error) {
+	var id int
+	err := c.do(ctx, c.createInvoiceRequest(inv, c.password), &id)
+	if err != nil {
+		return 0, fmt.Errorf("cannot create invoice %q: %w", inv.Ref, err)
+	}
+	return id, nil
+}
+
+// FindInvoice returns the id of a customer invoice of the partner with the reference of the invoice, or 0 if there is
+// none. Cancelled invoices are ignored.
+func (c *Client) FindInvoice(ctx context.Context, inv Invoice) (int, error) {
+	domain := []interface{}{
+		[]interface{}{"move_type", "=", "out_invoice"},
+		[]interface{}{"partner_id", "=", inv.PartnerID},
+		[]interface{}{"ref", "=", inv.Ref},
+		[]interface{}{"state", "!=", "cancel"},
+	}
+	var ids []int
+	err := c.call(ctx, "object", "execute_kw", []interface{}{
+		c.database, c.uid, c.password, "account.move", "search", []interface{}{domain},
+	}, &ids)
+	if err != nil {
+		return 0, fmt.Errorf("cannot search invoice %q of partner %d: %w", inv.Ref, inv.PartnerID, err)
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	return ids[0], nil
+}
+
+func (c *Client) newRequest(service, method string, args []interface{}) Request {
+	c.id++
+	return Request{
+		JSONRPC: "2.0",
+		Method:  "call",
+		Params:  Params{Service: service, Method: method, Args: args},
+		ID:      c.id,
+	}
+}
+
+func (c *Client) call(ctx context.Context, service, method string, args []interface{}, result interface{}) error {
+	return c.do(ctx, c.newRequest(service, method, args), result)
+}
+
+func (c *Client) do(ctx context.Context, request Request, result interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("odoo returned %s", resp.Status)
+	}
+
+	var res response
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return fmt.Errorf("cannot decode odoo response: %w", err)
+	}
+	if res.Error != nil {
+		return res.Error
+	}
+	return json.Unmarshal(res.Result, result)
+}
@@ -0,0 +1,153 @@
+package odoo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubOdoo is a minimal stand-in for the /jsonrpc endpoint of Odoo. It accepts the login "user"/"secret" only,
+// records all the invoices created and searches them by partner and reference.
+func stubOdoo(t *testing.T, invoices *[]map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/jsonrpc", r.URL.Path)
+		var req Request
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		require.Equal(t, "2.0", req.JSONRPC)
+		require.Equal(t, "call", req.Method)
+
+		var result interface{}
+		switch req.Params.Service + "." + req.Params.Method {
+		case "common.login":
+			result = false
+			if req.Params.Args[0] == "odoo" && req.Params.Args[1] == "user" && req.Params.Args[2] == "secret" {
+				result = 7
+			}
+		case "object.execute_kw":
+			args := req.Params.Args
+			if args[1] != float64(7) || args[2] != "secret" {
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{"id": req.ID, "error": map[string]interface{}{
+					"code": 100, "message": "Odoo Session Expired",
+				}})
+				return
+			}
+			require.Equal(t, "account.move", args[3])
+			switch args[4] {
+			case "create":
+				*invoices = append(*invoices, args[5].([]interface{})[0].(map[string]interface{}))
+				result = 40 + len(*invoices)
+			case "search":
+				domain := map[string]interface{}{}
+				for _, term := range args[5].([]interface{})[0].([]interface{}) {
+					domain[term.([]interface{})[0].(string)] = term.([]interface{})[2]
+				}
+				ids := []int{}
+				for i, invoice := range *invoices {
+					if invoice["partner_id"] == domain["partner_id"] && invoice["ref"] == domain["ref"] {
+						ids = append(ids, 41+i)
+					}
+				}
+				result = ids
+			default:
+				t.Fatalf("unexpected method %s", args[4])
+			}
+		default:
+			t.Fatalf("unexpected call %s.%s", req.Params.Service, req.Params.Method)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": result})
+	}))
+}
+
+func TestCreateInvoice(t *testing.T) {
+	var invoices []map[string]interface{}
+	server := stubOdoo(t, &invoices)
+	defer server.Close()
+	ctx := context.Background()
+
+	client := NewClient(server.URL+"/", "odoo", "user", "secret")
+	require.NoError(t, client.Login(ctx))
+
+	id, err := client.CreateInvoice(ctx, Invoice{
+		PartnerID: 12,
+		Ref:       "2022-10",
+		Lines: []InvoiceLine{
+			{ProductID: 1401, Name: "Object Storage - Storage (cloudscale.ch) - testnamespace", Quantity: "45.5", PriceUnit: "0.0033", Discount: "0"},
+			{ProductID: 1405, Name: "Object Storage - Requests (cloudscale.ch) - testnamespace", Quantity: "12.345", PriceUnit: "0.0055", Discount: "10"},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 41, id)
+
+	require.Len(t, invoices, 1)
+	assert.Equal(t, "out_invoice", invoices[0]["move_type"])
+	assert.Equal(t, float64(12), invoices[0]["partner_id"])
+	assert.Equal(t, "2022-10", invoices[0]["ref"])
+	lines := invoices[0]["invoice_line_ids"].([]interface{})
+	require.Len(t, lines, 2)
+	assert.Equal(t, []interface{}{float64(0), float64(0), map[string]interface{}{
+		"product_id": float64(1405),
+		"name":       "Object Storage - Requests (cloudscale.ch) - testnamespace",
+		"quantity":   12.345,
+		"price_unit": 0.0055,
+		"discount":   float64(10),
+	}}, lines[1])
+}
+
+func TestLoginFailed(t *testing.T) {
+	server := stubOdoo(t, nil)
+	defer server.Close()
+
+	client := NewClient(server.URL, "odoo", "user", "wrong")
+	assert.Error(t, client.Login(context.Background()))
+}
+
+func TestCreateInvoiceError(t *testing.T) {
+	var invoices []map[string]interface{}
+	server := stubOdoo(t, &invoices)
+	defer server.Close()
+
+	// not logged in
+	client := NewClient(server.URL, "odoo", "user", "secret")
+	_, err := client.CreateInvoice(context.Background(), Invoice{PartnerID: 12})
+	var odooErr *Error
+	require.ErrorAs(t, err, &odooErr)
+	assert.Equal(t, 100, odooErr.Code)
+	assert.Empty(t, invoices)
+}
+
+func TestFindInvoice(t *testing.T) {
+	var invoices []map[string]interface{}
+	server := stubOdoo(t, &invoices)
+	defer server.Close()
+	ctx := context.Background()
+
+	client := NewClient(server.URL, "odoo", "user", "secret")
+	require.NoError(t, client.Login(ctx))
+	invoice := Invoice{PartnerID: 12, Ref: "2022-10"}
+
+	id, err := client.FindInvoice(ctx, invoice)
+	require.NoError(t, err)
+	assert.Zero(t, id)
+
+	_, err = client.CreateInvoice(ctx, invoice)
+	require.NoError(t, err)
+	id, err = client.FindInvoice(ctx, invoice)
+	require.NoError(t, err)
+	assert.Equal(t, 41, id)
+
+	id, err = client.FindInvoice(ctx, Invoice{PartnerID: 12, Ref: "2022-11"})
+	require.NoError(t, err)
+	assert.Zero(t, id, "the reference must match")
+}
+
+func TestCreateInvoiceRequest(t *testing.T) {
+	client := NewClient("", "odoo", "user", "secret")
+	request := client.CreateInvoiceRequest(Invoice{PartnerID: 12, Ref: "2022-10"})
+	assert.Equal(t, redacted, request.Params.Args[2], "the password must not be printed")
+	assert.NotContains(t, request.Params.Args, "secret")
+}
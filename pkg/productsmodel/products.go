@@ -22,6 +22,16 @@ func GetBySource(ctx context.Context, tx *sqlx.Tx, source string) (*db.Product,
 	return &products[0], nil
 }
 
+// GetAll returns all products ordered by source and validity.
+func GetAll(ctx context.Context, tx *sqlx.Tx) ([]db.Product, error) {
+	var products []db.Product
+	err := sqlx.SelectContext(ctx, tx, &products, `SELECT products.* FROM products ORDER BY source, during`)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get products: %w", err)
+	}
+	return products, nil
+}
+
 func getBySourceQueryAndTime(ctx context.Context, tx *sqlx.Tx, sourceQuery string, timestamp time.Time) ([]db.Product, error) {
 	var products []db.Product
 	err := sqlx.SelectContext(ctx, tx, &products,
@@ -35,6 +45,12 @@ func getBySourceQueryAndTime(ctx context.Context, tx *sqlx.Tx, sourceQuery strin
 	return products, nil
 }
 
+// GetCandidates returns all products valid at the given time whose source has the same query as the given source.
+// GetBestMatch picks the best match among them.
+func GetCandidates(ctx context.Context, tx *sqlx.Tx, source string, timestamp time.Time) ([]db.Product, error) {
+	return getBySourceQueryAndTime(ctx, tx, tokenmatcher.NewTokenizedSource(source).Tokens[0], timestamp)
+}
+
 func GetBestMatch(ctx context.Context, tx *sqlx.Tx, source string, timestamp time.Time) (*db.Product, error) {
 	tokenizedSource := tokenmatcher.NewTokenizedSource(source)
 	candidateProducts, err := getBySourceQueryAndTime(ctx, tx, tokenizedSource.Tokens[0], timestamp)
@@ -48,6 +64,9 @@ func GetBestMatch(ctx context.Context, tx *sqlx.Tx, source string, timestamp tim
 	}
 
 	match := tokenmatcher.FindBestMatch(tokenizedSource, candidateSourcePatterns)
+	if match == nil {
+		return nil, nil
+	}
 
 	for _, candidateProduct := range candidateProducts {
 		if candidateProduct.Source == match.String() {
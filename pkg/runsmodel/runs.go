@@ -0,0 +1,122 @@
+package runsmodel
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// Run is a single execution of the collector.
+type Run struct {
+	Id string
+
+	Version string
+	Commit  string
+
+	StartedAt  time.Time    `db:"started_at"`
+	FinishedAt sql.NullTime `db:"finished_at"`
+
+	// Days is a comma separated list of the days (YYYY-MM-DD) written by the run.
+	Days string
+	// PendingDays is a comma separated list of the days (YYYY-MM-DD) which were not complete yet and have been deferred.
+	PendingDays string `db:"pending_days"`
+
+	FactsCreated int `db:"facts_created"`
+	FactsUpdated int `db:"facts_updated"`
+	// Anomalies is the number of facts whose quantity deviated from their baseline, FactsHeld the number of those which
+	// have been held back or rejected before instead of written.
+	Anomalies int `db:"anomalies"`
+	FactsHeld int `db:"facts_held"`
+
+	Status string
+	Error  string
+}
+
+// EnsureTables creates the collector_runs and collector_run_facts tables if they don't exist yet.
+// The tables are not part of the appuio-cloud-reporting schema, they are owned by this collector.
+func EnsureTables(ctx context.Context, tx *sqlx.Tx) error {
+	_, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS collector_runs (
+  id             uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  version        text NOT NULL,
+  commit         text NOT NULL,
+  started_at     timestamp with time zone NOT NULL,
+  finished_at    timestamp with time zone,
+  days           text NOT NULL DEFAULT '',
+  pending_days   text NOT NULL DEFAULT '',
+  facts_created  integer NOT NULL DEFAULT 0,
+  facts_updated  integer NOT NULL DEFAULT 0,
+  status         text NOT NULL,
+  error          text NOT NULL DEFAULT ''
+)`)
+	if err != nil {
+		return fmt.Errorf("cannot create table collector_runs: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `ALTER TABLE collector_runs
+  ALTER COLUMN id SET DEFAULT gen_random_uuid(),
+  ADD COLUMN IF NOT EXISTS anomalies   integer NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS facts_held  integer NOT NULL DEFAULT 0`)
+	if err != nil {
+		return fmt.Errorf("cannot update columns of collector_runs: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS collector_run_facts (
+  fact_id     uuid PRIMARY KEY,
+  run_id      uuid NOT NULL,
+  written_at  timestamp with time zone NOT NULL DEFAULT now(),
+
+  CONSTRAINT fk_fact
+    FOREIGN KEY(fact_id)
+    REFERENCES facts(id),
+
+  CONSTRAINT fk_run
+    FOREIGN KEY(run_id)
+    REFERENCES collector_runs(id)
+)`)
+	if err != nil {
+		return fmt.Errorf("cannot create table collector_run_facts: %w", err)
+	}
+	return nil
+}
+
+// Create records the run. The id is generated by the database, the id of in is ignored.
+func Create(p db.NamedPreparer, in *Run) (*Run, error) {
+	var run Run
+	err := db.GetNamed(p, &run,
+		"INSERT INTO collector_runs (version, commit, started_at, finished_at, days, pending_days, facts_created, facts_updated, anomalies, facts_held, status, error) VALUES (:version, :commit, :started_at, :finished_at, :days, :pending_days, :facts_created, :facts_updated, :anomalies, :facts_held, :status, :error) RETURNING *", in)
+	if err != nil {
+		err = fmt.Errorf("cannot create run %v: %w", in, err)
+	}
+	return &run, err
+}
+
+func Update(p db.NamedPreparer, in *Run) error {
+	var run Run
+	err := db.GetNamed(p, &run,
+		"UPDATE collector_runs SET finished_at=:finished_at, days=:days, pending_days=:pending_days, facts_created=:facts_created, facts_updated=:facts_updated, anomalies=:anomalies, facts_held=:facts_held, status=:status, error=:error WHERE id=:id RETURNING *", in)
+	if err != nil {
+		err = fmt.Errorf("cannot update run %v: %w", in, err)
+	}
+	return err
+}
+
+// LinkFact records that the fact with the given id has last been written by the run with the given id.
+func LinkFact(ctx context.Context, tx *sqlx.Tx, factId, runId string) error {
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO collector_run_facts (fact_id, run_id) VALUES ($1, $2)
+                  ON CONFLICT (fact_id) DO UPDATE SET run_id = EXCLUDED.run_id, written_at = now()`,
+		factId, runId)
+	if err != nil {
+		return fmt.Errorf("cannot link fact %s to run %s: %w", factId, runId, err)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package tenantmapping
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+/*
+Entry maps a bucket to a namespace, and a namespace to a tenant.
+
+Bucket is either the name of a bucket or a pattern as understood by path.Match, e.g. "backup-*". If Bucket is empty,
+the entry only maps Namespace to Tenant. Tenant may be empty for bucket entries, if the tenant of the namespace is
+known otherwise. Cluster is the name of the Kubernetes cluster of the namespace of a bucket entry. It is needed if a
+namespace of the same name exists in more than one cluster.
+*/
+type Entry struct {
+	Bucket    string `json:"bucket,omitempty"`
+	Cluster   string `json:"cluster,omitempty"`
+	Namespace string `json:"namespace"`
+	Tenant    string `json:"tenant,omitempty"`
+}
+
+func (e Entry) isPattern() bool {
+	return strings.ContainsAny(e.Bucket, `*?[\`)
+}
+
+/*
+Mapping maps buckets without a Bucket resource to namespaces, and namespaces to tenants.
+
+Buckets are looked up by their exact name first, then by the patterns in the order of the entries. A nil Mapping maps
+nothing.
+*/
+type Mapping struct {
+	buckets          map[string]Entry
+	patterns         []Entry
+	namespaceTenants map[string]string
+}
+
+// Load reads a mapping from a JSON file containing a list of entries.
+func Load(file string) (*Mapping, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open tenant mapping file: %w", err)
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	var entries []Entry
+	if err := dec.Decode(&entries); err != nil {
+		return nil, fmt.Errorf("cannot parse tenant mapping file %s: %w", file, err)
+	}
+	m, err := New(entries)
+	if err != nil {
+		return nil, fmt.Errorf("tenant mapping file %s: %w", file, err)
+	}
+	return m, nil
+}
+
+// New validates the entries and returns their mapping. A bucket name must only be mapped once, and a namespace must
+// not be mapped to different tenants.
+func New(entries []Entry) (*Mapping, error) {
+	m := &Mapping{
+		buckets:          map[string]Entry{},
+		namespaceTenants: map[string]string{},
+	}
+	for i, e := range entries {
+		if e.Namespace == "" {
+			return nil, fmt.Errorf("entry %d: namespace must be set", i)
+		}
+		if e.Bucket == "" && e.Tenant == "" {
+			return nil, fmt.Errorf("entry %d: either bucket or tenant must be set", i)
+		}
+		if e.Bucket == "" && e.Cluster != "" {
+			return nil, fmt.Errorf("entry %d: cluster can only be set for buckets", i)
+		}
+
+		if e.isPattern() {
+			if _, err := path.Match(e.Bucket, ""); err != nil {
+				return nil, fmt.Errorf("entry %d: invalid bucket pattern %q: %w", i, e.Bucket, err)
+			}
+			m.patterns = append(m.patterns, e)
+		} else if e.Bucket != "" {
+			if existing, ok := m.buckets[e.Bucket]; ok {
+				return nil, fmt.Errorf("entry %d: bucket %q is already mapped to namespace %q", i, e.Bucket, existing.Namespace)
+			}
+			m.buckets[e.Bucket] = e
+		}
+
+		if e.Tenant != "" {
+			if tenant, ok := m.namespaceTenants[e.Namespace]; ok && tenant != e.Tenant {
+				return nil, fmt.Errorf("entry %d: namespace %q is already mapped to tenant %q", i, e.Namespace, tenant)
+			}
+			m.namespaceTenants[e.Namespace] = e.Tenant
+		}
+	}
+	return m, nil
+}
+
+// BucketNamespace returns the namespace of the bucket and its cluster, which is empty if not set.
+func (m *Mapping) BucketNamespace(bucket string) (namespace, cluster string, ok bool) {
+	if m == nil {
+		return "", "", false
+	}
+	if e, ok := m.buckets[bucket]; ok {
+		return e.Namespace, e.Cluster, true
+	}
+	for _, e := range m.patterns {
+		// the patterns have been validated, so there is no error
+		if matched, _ := path.Match(e.Bucket, bucket); matched {
+			return e.Namespace, e.Cluster, true
+		}
+	}
+	return "", "", false
+}
+
+// NamespaceTenant returns the tenant of the namespace.
+func (m *Mapping) NamespaceTenant(namespace string) (string, bool) {
+	if m == nil {
+		return "", false
+	}
+	tenant, ok := m.namespaceTenants[namespace]
+	return tenant, ok
+}
@@ -0,0 +1,70 @@
+package tenantmapping
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapping(t *testing.T) {
+	m, err := New([]Entry{
+		{Bucket: "backup-*", Namespace: "backups", Tenant: "vshn"},
+		{Bucket: "backup-acme", Cluster: "cluster-a", Namespace: "acme-backups"},
+		{Bucket: "backup-a*", Namespace: "never"},
+		{Namespace: "acme-backups", Tenant: "acme"},
+	})
+	require.NoError(t, err)
+
+	ns, cluster, ok := m.BucketNamespace("backup-acme")
+	assert.True(t, ok)
+	assert.Equal(t, "acme-backups", ns, "exact names win over patterns")
+	assert.Equal(t, "cluster-a", cluster)
+
+	ns, cluster, ok = m.BucketNamespace("backup-abc")
+	assert.True(t, ok)
+	assert.Equal(t, "backups", ns, "the first pattern wins")
+	assert.Empty(t, cluster)
+
+	_, _, ok = m.BucketNamespace("terraform-state")
+	assert.False(t, ok)
+
+	tenant, ok := m.NamespaceTenant("acme-backups")
+	assert.True(t, ok)
+	assert.Equal(t, "acme", tenant)
+	tenant, _ = m.NamespaceTenant("backups")
+	assert.Equal(t, "vshn", tenant)
+
+	var nilMapping *Mapping
+	_, _, ok = nilMapping.BucketNamespace("backup-acme")
+	assert.False(t, ok)
+}
+
+func TestNewInvalid(t *testing.T) {
+	for name, entries := range map[string][]Entry{
+		"no namespace":         {{Bucket: "a", Tenant: "b"}},
+		"no bucket nor tenant": {{Namespace: "a"}},
+		"cluster of tenant":    {{Cluster: "a", Namespace: "a", Tenant: "a"}},
+		"invalid pattern":      {{Bucket: "a[", Namespace: "a"}},
+		"duplicate bucket":     {{Bucket: "a", Namespace: "a"}, {Bucket: "a", Namespace: "b"}},
+		"conflicting tenants":  {{Namespace: "a", Tenant: "a"}, {Bucket: "b", Namespace: "a", Tenant: "b"}},
+	} {
+		_, err := New(entries)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "mapping.json")
+	require.NoError(t, os.WriteFile(file, []byte(`[{"bucket": "tf-*", "namespace": "terraform", "tenant": "vshn"}]`), 0o600))
+	m, err := Load(file)
+	require.NoError(t, err)
+	ns, _, _ := m.BucketNamespace("tf-state")
+	assert.Equal(t, "terraform", ns)
+
+	require.NoError(t, os.WriteFile(file, []byte(`[{"bucket": "tf-*", "namespace": "terraform", "owner": "vshn"}]`), 0o600))
+	_, err = Load(file)
+	assert.Error(t, err, "unknown fields are rejected")
+}
@@ -1,18 +1,27 @@
 package tokenmatcher
 
 import (
+	"fmt"
+	"path"
+	"sort"
 	"strings"
 )
 
+// MaxPatternTokens is the maximum number of tokens of a source generatePatterns supports. The number of patterns grows
+// exponentially with the number of tokens.
+const MaxPatternTokens = 10
+
 func NewTokenizedSource(source string) *TokenizedSource {
 	return &TokenizedSource{
 		Tokens: strings.Split(source, ":"),
 	}
 }
 
-func generatePatterns(reference *TokenizedSource) []*TokenizedSource {
-	if len(reference.Tokens) > 10 {
-		panic("No more than 10 tokens supported. More tokens lead to an explosion of possible wildcard positions")
+// generatePatterns returns all the patterns of exact tokens and wildcards matching the reference in order of
+// precedence. The tests compare the precedence of FindBestMatch and Rank against them.
+func generatePatterns(reference *TokenizedSource) ([]*TokenizedSource, error) {
+	if len(reference.Tokens) > MaxPatternTokens {
+		return nil, fmt.Errorf("no more than %d tokens supported, got %d: more tokens lead to an explosion of possible wildcard positions", MaxPatternTokens, len(reference.Tokens))
 	}
 
 	var patterns []*TokenizedSource
@@ -41,18 +50,256 @@ func generatePatterns(reference *TokenizedSource) []*TokenizedSource {
 		}
 	}
 
-	return patterns
+	return patterns, nil
 }
 
+// Rank returns the indexes of the candidates matching the reference in order of precedence, the first one is the best
+// match of FindBestMatch. Candidates which don't match the reference are left out. It works for any number of tokens.
+func Rank(reference *TokenizedSource, candidates []*TokenizedSource) []int {
+	type rankedMatch struct {
+		index int
+		ranks []tokenRank
+	}
+	var matches []rankedMatch
+	for i, candidate := range candidates {
+		if ranks, ok := match(reference, candidate); ok {
+			matches = append(matches, rankedMatch{index: i, ranks: ranks})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		switch {
+		case moreSpecific(a.ranks, b.ranks):
+			return true
+		case moreSpecific(b.ranks, a.ranks):
+			return false
+		}
+		return candidates[a.index].String() < candidates[b.index].String()
+	})
+	indexes := make([]int, len(matches))
+	for i, m := range matches {
+		indexes[i] = m.index
+	}
+	return indexes
+}
+
+/*
+FindBestMatch returns the candidate matching the reference with the highest precedence, nil if none matches.
+A candidate matches if it has at most as many tokens as the reference and every token matches the reference's token at
+the same position, see matchToken. The first token (the query) must be equal.
+Among the matches, the candidate with more tokens wins. If two matches have the same number of tokens, the one with
+the more specific token at the leftmost position where they differ wins, see tokenRank: exact tokens take precedence
+over alternatives, alternatives over globs, and globs over wildcards. Among alternatives, fewer alternatives take
+precedence, among globs the longer literal prefix. If the candidates are still equally specific, the smaller source
+string wins, so the result does not depend on the order of the candidates.
+*/
 func FindBestMatch(reference *TokenizedSource, candidates []*TokenizedSource) *TokenizedSource {
-	patterns := generatePatterns(reference)
-	for _, pattern := range patterns {
-		for _, candidate := range candidates {
-			if candidate.String() == pattern.String() {
-				return candidate
+	var best *TokenizedSource
+	var bestRanks []tokenRank
+	for _, candidate := range candidates {
+		ranks, ok := match(reference, candidate)
+		if !ok {
+			continue
+		}
+		if best == nil || moreSpecific(ranks, bestRanks) ||
+			!moreSpecific(bestRanks, ranks) && candidate.String() < best.String() {
+			best = candidate
+			bestRanks = ranks
+		}
+	}
+	return best
+}
+
+// Tie returns whether a and b are equally specific, so that the best match of a reference both match is decided by
+// their source strings only. Identical sources are no tie.
+func Tie(a, b *TokenizedSource) bool {
+	if a.String() == b.String() {
+		return false
+	}
+	aRanks, bRanks := ranks(a), ranks(b)
+	return !moreSpecific(aRanks, bRanks) && !moreSpecific(bRanks, aRanks)
+}
+
+// Matches returns whether the candidate matches the reference, regardless of its precedence.
+func Matches(reference, candidate *TokenizedSource) bool {
+	_, ok := match(reference, candidate)
+	return ok
+}
+
+// tokenKind is how a token of a candidate matches, in order of precedence.
+type tokenKind int
+
+const (
+	// exactToken is equal to the reference's token.
+	exactToken tokenKind = iota
+	// alternativesToken is a list of exact tokens, e.g. "{rma,lpg}".
+	alternativesToken
+	// globToken is a pattern as understood by path.Match, e.g. "team-*".
+	globToken
+	// wildcardToken is "*", it matches any token except the first and the last one.
+	wildcardToken
+)
+
+// tokenRank is the specificity of a token of a candidate. A lower kind is more specific, and for the same kind a
+// lower detail: the number of alternatives, or the negative length of the literal prefix of a glob.
+type tokenRank struct {
+	kind   tokenKind
+	detail int
+}
+
+func rankOf(token string) tokenRank {
+	switch kind := kindOf(token); kind {
+	case alternativesToken:
+		return tokenRank{kind: kind, detail: len(alternatives(token))}
+	case globToken:
+		return tokenRank{kind: kind, detail: -strings.IndexAny(token, `*?[\`)}
+	default:
+		return tokenRank{kind: kind}
+	}
+}
+
+func ranks(candidate *TokenizedSource) []tokenRank {
+	r := make([]tokenRank, len(candidate.Tokens))
+	for i, token := range candidate.Tokens {
+		r[i] = rankOf(token)
+	}
+	return r
+}
+
+// match returns whether the candidate matches the reference, and the rank of every token of the candidate.
+func match(reference, candidate *TokenizedSource) ([]tokenRank, bool) {
+	n := len(candidate.Tokens)
+	if n > len(reference.Tokens) {
+		return nil, false
+	}
+	r := make([]tokenRank, n)
+	for i, token := range candidate.Tokens {
+		kind, ok := matchToken(token, reference.Tokens[i])
+		if !ok || (i == 0 && kind != exactToken) || ((i == 0 || i == n-1) && kind == wildcardToken) {
+			return nil, false
+		}
+		r[i] = rankOf(token)
+		if kind == exactToken {
+			// a token equal to the reference's token matches exactly, whatever it looks like
+			r[i] = tokenRank{kind: exactToken}
+		}
+	}
+	return r, true
+}
+
+// matchToken returns whether the token of a candidate matches the token of the reference, and how.
+func matchToken(pattern, token string) (tokenKind, bool) {
+	switch {
+	case pattern == token:
+		return exactToken, true
+	case pattern == "*":
+		return wildcardToken, true
+	case isAlternatives(pattern):
+		for _, alternative := range alternatives(pattern) {
+			if alternative == token {
+				return alternativesToken, true
 			}
 		}
+		return alternativesToken, false
+	case isGlob(pattern):
+		matched, err := path.Match(pattern, token)
+		return globToken, err == nil && matched
 	}
+	return exactToken, false
+}
+
+// alternatives returns the tokens of the alternatives token, e.g. "rma" and "lpg" of "{rma,lpg}".
+func alternatives(token string) []string {
+	return strings.Split(token[1:len(token)-1], ",")
+}
+
+func isAlternatives(token string) bool {
+	return len(token) >= 2 && strings.HasPrefix(token, "{") && strings.HasSuffix(token, "}")
+}
+
+func isGlob(token string) bool {
+	return strings.ContainsAny(token, `*?[\`)
+}
 
+// moreSpecific returns whether a match with the token ranks a takes precedence over a match with the token ranks b.
+func moreSpecific(a, b []tokenRank) bool {
+	if len(a) != len(b) {
+		return len(a) > len(b)
+	}
+	for i := range a {
+		if a[i].kind != b[i].kind {
+			return a[i].kind < b[i].kind
+		}
+		if a[i].detail != b[i].detail {
+			return a[i].detail < b[i].detail
+		}
+	}
+	return false
+}
+
+// Validate returns an error if the candidate contains a token which can never match: a first token which is not exact,
+// a wildcard as the last token, or a malformed glob.
+func Validate(candidate *TokenizedSource) error {
+	for i, token := range candidate.Tokens {
+		kind := kindOf(token)
+		if i == 0 && kind != exactToken {
+			return fmt.Errorf("the first token %q must be exact", token)
+		}
+		if i > 0 && i == len(candidate.Tokens)-1 && kind == wildcardToken {
+			return fmt.Errorf("the last token must not be a wildcard")
+		}
+		if kind == globToken {
+			if _, err := path.Match(token, ""); err != nil {
+				return fmt.Errorf("invalid glob %q: %w", token, err)
+			}
+		}
+	}
+	return nil
+}
+
+/*
+Examples returns tokens matched by the token of a candidate, to construct references the candidate matches: the token
+itself if it is exact, every alternative, or a token matching a glob. It returns nil for a wildcard, which matches any
+token, and for globs it cannot construct a token for.
+*/
+func Examples(token string) []string {
+	switch kindOf(token) {
+	case exactToken:
+		return []string{token}
+	case alternativesToken:
+		return alternatives(token)
+	case globToken:
+		if strings.Contains(token, "[") {
+			return nil
+		}
+		example := strings.NewReplacer(`\*`, "*", `\?`, "?", `\\`, `\`, "*", "", "?", "x").Replace(token)
+		if matched, err := path.Match(token, example); err != nil || !matched {
+			return nil
+		}
+		return []string{example}
+	}
 	return nil
 }
+
+// IsExact returns whether the token of a candidate only matches a token equal to it.
+func IsExact(token string) bool {
+	return kindOf(token) == exactToken
+}
+
+// IsGlob returns whether the token of a candidate is a glob, e.g. "team-*".
+func IsGlob(token string) bool {
+	return kindOf(token) == globToken
+}
+
+// kindOf returns how a token of a candidate matches.
+func kindOf(token string) tokenKind {
+	switch {
+	case token == "*":
+		return wildcardToken
+	case isAlternatives(token):
+		return alternativesToken
+	case isGlob(token):
+		return globToken
+	}
+	return exactToken
+}
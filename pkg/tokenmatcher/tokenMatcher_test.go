@@ -1,6 +1,8 @@
 package tokenmatcher
 
 import (
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -43,3 +45,145 @@ func Test(t *testing.T) {
 	testBestMatch(t, "a:b:c:d", []string{"a", "a:b"}, NewTokenizedSource("a:b"))
 	testBestMatch(t, "a:b:c:d", []string{"a:b", "a"}, NewTokenizedSource("a:b"))
 }
+
+func TestManyTokens(t *testing.T) {
+	reference := "a:b:c:d:e:f:g:h:i:j:k:l:m:n:o:p:q:r:s:t"
+	testBestMatch(t, reference, []string{"a:*:c:d:e:f:g:h:i:j:k:l:m:n:o:p:q:r:s:t", "a:b:c:*:e"}, NewTokenizedSource("a:*:c:d:e:f:g:h:i:j:k:l:m:n:o:p:q:r:s:t"))
+	testBestMatch(t, reference, []string{"a:*:*:d:e:f:g:h:i:j:k:l:m:n:o:p:q:r:s:t", "a:b:*:*:*:*:*:*:*:*:*:*:*:*:*:*:*:*:*:t"}, NewTokenizedSource("a:b:*:*:*:*:*:*:*:*:*:*:*:*:*:*:*:*:*:t"))
+	testBestMatch(t, reference, []string{"a:*:*:d:e:f:g:h:i:j:k:l:m:n:o:p:q:r:s:x"}, nil)
+
+	candidates := []*TokenizedSource{
+		NewTokenizedSource("a:b:*:*:*:*:*:*:*:*:*:*:*:*:*:*:*:*:*:t"),
+		NewTokenizedSource("a:*:*:d:e:f:g:h:i:j:k:l:m:n:o:p:q:r:s:x"),
+		NewTokenizedSource("a:*:*:d:e:f:g:h:i:j:k:l:m:n:o:p:q:r:s:t"),
+	}
+	if ranked := Rank(NewTokenizedSource(reference), candidates); !reflect.DeepEqual(ranked, []int{0, 2}) {
+		t.Errorf("Rank should have been [0 2], was %v", ranked)
+	}
+}
+
+// TestPatternsPrecedence checks that FindBestMatch picks the candidate equal to the first pattern of generatePatterns,
+// and that Rank orders the patterns the same way.
+func TestPatternsPrecedence(t *testing.T) {
+	reference := NewTokenizedSource("a:b:c:d:e")
+	patterns, err := generatePatterns(reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reversed := make([]*TokenizedSource, len(patterns))
+	for i, pattern := range patterns {
+		reversed[len(patterns)-1-i] = pattern
+	}
+	for i, index := range Rank(reference, reversed) {
+		if !patterns[i].Equals(reversed[index]) {
+			t.Errorf("pattern %d should have been '%s', was '%s'", i, patterns[i], reversed[index])
+		}
+	}
+	for i := range patterns {
+		// every pattern must win against all the patterns after it, regardless of the order of the candidates
+		for j := i + 1; j < len(patterns); j++ {
+			for _, candidates := range [][]*TokenizedSource{{patterns[i], patterns[j]}, {patterns[j], patterns[i]}} {
+				if bestMatch := FindBestMatch(reference, candidates); !patterns[i].Equals(bestMatch) {
+					t.Errorf("best Match of %s and %s should have been '%s', was '%s'", patterns[i], patterns[j], patterns[i], bestMatch)
+				}
+			}
+		}
+	}
+}
+
+func TestAlternativesAndGlobs(t *testing.T) {
+	reference := "q:rma:team-a:ns"
+	testBestMatch(t, reference, []string{"q:{rma,lpg}:team-a:ns"}, NewTokenizedSource("q:{rma,lpg}:team-a:ns"))
+	testBestMatch(t, reference, []string{"q:{lpg,xyz}:team-a:ns"}, nil)
+	testBestMatch(t, reference, []string{"q:rma:team-*"}, NewTokenizedSource("q:rma:team-*"))
+	testBestMatch(t, reference, []string{"q:rma:other-*"}, nil)
+	testBestMatch(t, reference, []string{"q:rma:team-a:n?"}, NewTokenizedSource("q:rma:team-a:n?"))
+	testBestMatch(t, reference, []string{"q:rma:team-a:*"}, nil)
+	testBestMatch(t, reference, []string{"{q,r}:rma"}, nil)
+	testBestMatch(t, reference, []string{"q:rma:[:ns"}, nil)
+
+	// exact > alternatives > glob > wildcard
+	precedence := []string{"q:rma:team-a:ns", "q:rma:{team-a,team-b}:ns", "q:rma:team-*:ns", "q:rma:*:ns"}
+	for i := range precedence {
+		for j := i + 1; j < len(precedence); j++ {
+			testBestMatch(t, reference, []string{precedence[j], precedence[i]}, NewTokenizedSource(precedence[i]))
+			testBestMatch(t, reference, []string{precedence[i], precedence[j]}, NewTokenizedSource(precedence[i]))
+		}
+	}
+
+	// more tokens still win, and the leftmost differing token decides
+	testBestMatch(t, reference, []string{"q:rma:team-a", "q:*:*:n*"}, NewTokenizedSource("q:*:*:n*"))
+	testBestMatch(t, reference, []string{"q:*:team-a:ns", "q:{rma,lpg}:*:ns"}, NewTokenizedSource("q:{rma,lpg}:*:ns"))
+	testBestMatch(t, reference, []string{"q:r*:team-a:ns", "q:{rma,lpg}:*:ns"}, NewTokenizedSource("q:{rma,lpg}:*:ns"))
+	testBestMatch(t, reference, []string{"q:rma:team-*:{ns,other}", "q:rma:team-*:ns"}, NewTokenizedSource("q:rma:team-*:ns"))
+}
+
+func TestValidate(t *testing.T) {
+	for _, valid := range []string{"q", "q:rma", "q:*:team-*:ns", "q:{rma,lpg}:*:n?", "q:rma:team-a:{ns,other}"} {
+		if err := Validate(NewTokenizedSource(valid)); err != nil {
+			t.Errorf("%s should be valid, got %v", valid, err)
+		}
+	}
+	for _, invalid := range []string{"*", "q*:rma", "{q,r}:rma", "q:rma:*", "q:team-[:ns"} {
+		if err := Validate(NewTokenizedSource(invalid)); err == nil {
+			t.Errorf("%s should be invalid", invalid)
+		}
+	}
+}
+
+func TestExamples(t *testing.T) {
+	examples := map[string][]string{
+		"rma":       {"rma"},
+		"{rma,lpg}": {"rma", "lpg"},
+		"team-*":    {"team-"},
+		"team-?-*":  {"team-x-"},
+		`team-\*`:   {"team-*"},
+		"*":         nil,
+		"team-[ab]": nil,
+		"team-[":    nil,
+	}
+	for token, expected := range examples {
+		actual := Examples(token)
+		if strings.Join(actual, ",") != strings.Join(expected, ",") || len(actual) != len(expected) {
+			t.Errorf("examples of %s should have been %v, were %v", token, expected, actual)
+		}
+	}
+}
+
+func TestTieBreak(t *testing.T) {
+	reference := "q:rma:team-a:ns"
+	for _, c := range []struct {
+		candidates []string
+		best       string
+	}{
+		// the longer literal prefix of a glob wins
+		{[]string{"q:rma:t*:ns", "q:rma:team-*:ns"}, "q:rma:team-*:ns"},
+		{[]string{"q:rma:?eam-a:ns", "q:rma:t*:ns"}, "q:rma:t*:ns"},
+		// fewer alternatives win
+		{[]string{"q:{rma,lpg,xyz}:team-a:ns", "q:{rma,lpg}:team-a:ns"}, "q:{rma,lpg}:team-a:ns"},
+		// equally specific candidates are decided by their source strings
+		{[]string{"q:{rma,xyz}:team-a:ns", "q:{rma,lpg}:team-a:ns"}, "q:{rma,lpg}:team-a:ns"},
+		{[]string{"q:rma:team-?:ns", "q:rma:team-*:ns"}, "q:rma:team-*:ns"},
+		// the kind still takes precedence over the details
+		{[]string{"q:r*:team-a:ns", "q:{rma,lpg,xyz}:*:ns"}, "q:{rma,lpg,xyz}:*:ns"},
+	} {
+		testBestMatch(t, reference, c.candidates, NewTokenizedSource(c.best))
+		reversed := []string{c.candidates[1], c.candidates[0]}
+		testBestMatch(t, reference, reversed, NewTokenizedSource(c.best))
+	}
+}
+
+func TestTie(t *testing.T) {
+	if !Tie(NewTokenizedSource("q:{rma,xyz}:a"), NewTokenizedSource("q:{rma,lpg}:a")) {
+		t.Errorf("alternatives of the same length should tie")
+	}
+	if !Tie(NewTokenizedSource("q:z:team-*"), NewTokenizedSource("q:z:team-?")) {
+		t.Errorf("globs with literal prefixes of the same length should tie")
+	}
+	if Tie(NewTokenizedSource("q:z:team-*"), NewTokenizedSource("q:z:t*")) {
+		t.Errorf("globs with literal prefixes of different length should not tie")
+	}
+	if Tie(NewTokenizedSource("q:z:a"), NewTokenizedSource("q:z:a")) {
+		t.Errorf("identical sources should not tie")
+	}
+}
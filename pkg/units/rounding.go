@@ -0,0 +1,119 @@
+package units
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// RoundingMode defines how a value is rounded to the number of decimals of a RoundingPolicy.
+type RoundingMode string
+
+const (
+	// RoundNone doesn't round at all, the value stays exact.
+	RoundNone RoundingMode = "none"
+	// RoundHalfUp rounds to the nearest value, ties away from zero.
+	RoundHalfUp RoundingMode = "half-up"
+	// RoundHalfEven rounds to the nearest value, ties to the even neighbour ("banker's rounding").
+	RoundHalfEven RoundingMode = "half-even"
+	// RoundUp rounds away from zero, e.g. to bill every started GB.
+	RoundUp RoundingMode = "up"
+	// RoundDown rounds towards zero.
+	RoundDown RoundingMode = "down"
+)
+
+// RoundingPolicy rounds values to a fixed number of decimals.
+type RoundingPolicy struct {
+	Mode     RoundingMode
+	Decimals int
+}
+
+// NoRounding keeps values exact.
+var NoRounding = RoundingPolicy{Mode: RoundNone}
+
+// ParseRoundingPolicy parses a policy in the form "<mode>:<decimals>" (e.g. "half-up:6", "up:0") or "none".
+func ParseRoundingPolicy(s string) (RoundingPolicy, error) {
+	if s == string(RoundNone) {
+		return NoRounding, nil
+	}
+	mode, decimalsStr, ok := strings.Cut(s, ":")
+	if !ok {
+		return RoundingPolicy{}, fmt.Errorf("invalid rounding policy %q, expected <mode>:<decimals> or %q", s, RoundNone)
+	}
+	decimals, err := strconv.Atoi(decimalsStr)
+	if err != nil || decimals < 0 {
+		return RoundingPolicy{}, fmt.Errorf("invalid number of decimals in rounding policy %q", s)
+	}
+	switch RoundingMode(mode) {
+	case RoundHalfUp, RoundHalfEven, RoundUp, RoundDown:
+	default:
+		return RoundingPolicy{}, fmt.Errorf("invalid rounding mode in rounding policy %q", s)
+	}
+	return RoundingPolicy{Mode: RoundingMode(mode), Decimals: decimals}, nil
+}
+
+func (p RoundingPolicy) String() string {
+	if p.Mode == RoundNone {
+		return string(RoundNone)
+	}
+	return fmt.Sprintf("%s:%d", p.Mode, p.Decimals)
+}
+
+// Round returns the value rounded according to the policy. The given value is not modified.
+func (p RoundingPolicy) Round(value *big.Rat) *big.Rat {
+	if p.Mode == RoundNone {
+		return new(big.Rat).Set(value)
+	}
+
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(p.Decimals)), nil)
+	scaled := new(big.Rat).Mul(value, new(big.Rat).SetInt(scale))
+
+	// quotient and remainder of the absolute value, truncated towards zero
+	num := new(big.Int).Abs(scaled.Num())
+	quo, rem := new(big.Int).QuoRem(num, scaled.Denom(), new(big.Int))
+
+	if rem.Sign() != 0 {
+		// compare 2*rem with the denominator to find out if we're below, at or above half
+		half := new(big.Int).Lsh(rem, 1).Cmp(scaled.Denom())
+		switch p.Mode {
+		case RoundUp:
+			quo.Add(quo, big.NewInt(1))
+		case RoundHalfUp:
+			if half >= 0 {
+				quo.Add(quo, big.NewInt(1))
+			}
+		case RoundHalfEven:
+			if half > 0 || (half == 0 && quo.Bit(0) == 1) {
+				quo.Add(quo, big.NewInt(1))
+			}
+		}
+	}
+
+	if scaled.Sign() < 0 {
+		quo.Neg(quo)
+	}
+	return new(big.Rat).SetFrac(quo, scale)
+}
+
+// FromFloat converts a float64 into the shortest decimal representing it exactly, e.g. 0.0033 becomes 33/10000 and
+// not the binary approximation of 0.0033. Use it for prices and discounts, which are stored as float64.
+func FromFloat(f float64) *big.Rat {
+	r, _ := new(big.Rat).SetString(strconv.FormatFloat(f, 'g', -1, 64))
+	return r
+}
+
+// maxDecimals is the number of decimals used by FormatDecimal for values without a finite decimal representation.
+const maxDecimals = 18
+
+// FormatDecimal formats the value as a decimal number without trailing zeros. Values with a finite decimal
+// representation of up to 18 decimals are formatted exactly, others are rounded half-up to 18 decimals.
+func FormatDecimal(value *big.Rat) string {
+	s := RoundingPolicy{Mode: RoundHalfUp, Decimals: maxDecimals}.Round(value).FloatString(maxDecimals)
+	s = strings.TrimRight(s, "0")
+	s = strings.TrimSuffix(s, ".")
+	if s == "-0" {
+		return "0"
+	}
+	return s
+}
@@ -0,0 +1,68 @@
+package units
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRoundingPolicy(t *testing.T) {
+	for s, expected := range map[string]RoundingPolicy{
+		"none":        NoRounding,
+		"half-up:6":   {Mode: RoundHalfUp, Decimals: 6},
+		"half-even:2": {Mode: RoundHalfEven, Decimals: 2},
+		"up:0":        {Mode: RoundUp, Decimals: 0},
+		"down:3":      {Mode: RoundDown, Decimals: 3},
+	} {
+		policy, err := ParseRoundingPolicy(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, expected, policy, s)
+		assert.Equal(t, s, policy.String())
+	}
+
+	for _, s := range []string{"", "half-up", "half-up:", "half-up:-1", "sideways:2", "up:x"} {
+		_, err := ParseRoundingPolicy(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestRound(t *testing.T) {
+	testRound := func(policy, value, expected string) {
+		p, err := ParseRoundingPolicy(policy)
+		require.NoError(t, err)
+		v, ok := new(big.Rat).SetString(value)
+		require.True(t, ok, value)
+		assert.Equal(t, expected, p.Round(v).FloatString(p.Decimals), "%s %s", policy, value)
+	}
+
+	testRound("half-up:2", "1.005", "1.01")
+	testRound("half-up:2", "1.0049", "1.00")
+	testRound("half-up:2", "-1.005", "-1.01")
+	testRound("half-even:2", "1.005", "1.00")
+	testRound("half-even:2", "1.015", "1.02")
+	testRound("half-even:2", "1.0051", "1.01")
+	testRound("up:0", "0.000000001", "1")
+	testRound("up:0", "3", "3")
+	testRound("down:0", "2.999", "2")
+	testRound("down:1", "-2.99", "-2.9")
+	testRound("half-up:9", "1/3", "0.333333333")
+
+	assert.Equal(t, "1/4", NoRounding.Round(big.NewRat(1, 4)).RatString())
+}
+
+func TestFromFloat(t *testing.T) {
+	assert.Equal(t, "33/10000", FromFloat(0.0033).RatString())
+	assert.Equal(t, "11/500", FromFloat(0.022).RatString())
+	assert.Equal(t, "0", FromFloat(0).RatString())
+}
+
+func TestFormatDecimal(t *testing.T) {
+	assert.Equal(t, "0", FormatDecimal(big.NewRat(0, 1)))
+	assert.Equal(t, "12", FormatDecimal(big.NewRat(12, 1)))
+	assert.Equal(t, "0.0033", FormatDecimal(big.NewRat(33, 10000)))
+	assert.Equal(t, "-1.5", FormatDecimal(big.NewRat(-3, 2)))
+	assert.Equal(t, "0.000000001", FormatDecimal(big.NewRat(1, 1000000000)))
+	assert.Equal(t, "0.333333333333333333", FormatDecimal(big.NewRat(1, 3)))
+}
@@ -0,0 +1,108 @@
+package units
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+// prefixes maps SI and binary prefixes to their exact factor.
+// "K" is not an SI prefix, but commonly used for kilo (e.g. "KReq"), so it is accepted as well.
+var prefixes = map[string]*big.Rat{
+	"k":  pow(1000, 1),
+	"K":  pow(1000, 1),
+	"M":  pow(1000, 2),
+	"G":  pow(1000, 3),
+	"T":  pow(1000, 4),
+	"P":  pow(1000, 5),
+	"Ki": pow(1024, 1),
+	"Mi": pow(1024, 2),
+	"Gi": pow(1024, 3),
+	"Ti": pow(1024, 4),
+	"Pi": pow(1024, 5),
+}
+
+func pow(base, exp int64) *big.Rat {
+	return new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(base), big.NewInt(exp), nil))
+}
+
+// Unit is a parsed unit, e.g. "GiBDay" is the base unit "BDay" with a factor of 1024^3.
+type Unit struct {
+	Name string
+	Base string
+	// Factor is the number of base units in one unit.
+	Factor *big.Rat
+}
+
+// Registry knows a set of base units and converts between all the (prefixed) units of the same base unit.
+type Registry struct {
+	bases map[string]bool
+}
+
+// NewRegistry returns a registry knowing the given base units.
+func NewRegistry(bases ...string) *Registry {
+	r := &Registry{bases: map[string]bool{}}
+	for _, base := range bases {
+		r.bases[base] = true
+	}
+	return r
+}
+
+// Default is the registry of the base units used for billing.
+//   - B: Bytes, e.g. transferred data
+//   - BDay: Byte-days, e.g. data stored during a day
+//   - Req: Requests
+//   - IPDay: IP addresses allocated during a day
+//   - vCPUHour: vCPUs allocated during an hour
+var Default = NewRegistry("B", "BDay", "Req", "IPDay", "vCPUHour")
+
+// Parse parses the given unit name into its base unit and factor.
+// The name is either a base unit or a prefix followed by a base unit.
+func (r *Registry) Parse(name string) (Unit, error) {
+	if r.bases[name] {
+		return Unit{Name: name, Base: name, Factor: big.NewRat(1, 1)}, nil
+	}
+	// try longer prefixes first, so "Ki" takes precedence over "K"
+	names := make([]string, 0, len(prefixes))
+	for prefix := range prefixes {
+		names = append(names, prefix)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if len(names[i]) != len(names[j]) {
+			return len(names[i]) > len(names[j])
+		}
+		return names[i] < names[j]
+	})
+	for _, prefix := range names {
+		if len(name) > len(prefix) && name[:len(prefix)] == prefix && r.bases[name[len(prefix):]] {
+			return Unit{Name: name, Base: name[len(prefix):], Factor: prefixes[prefix]}, nil
+		}
+	}
+	return Unit{}, fmt.Errorf("unknown unit %q", name)
+}
+
+// Factor returns the exact factor to convert a value in unit 'from' into unit 'to'.
+func (r *Registry) Factor(from, to string) (*big.Rat, error) {
+	fromUnit, err := r.Parse(from)
+	if err != nil {
+		return nil, err
+	}
+	toUnit, err := r.Parse(to)
+	if err != nil {
+		return nil, err
+	}
+	if fromUnit.Base != toUnit.Base {
+		return nil, fmt.Errorf("cannot convert %q to %q: incompatible base units %q and %q", from, to, fromUnit.Base, toUnit.Base)
+	}
+	return new(big.Rat).Quo(fromUnit.Factor, toUnit.Factor), nil
+}
+
+// Convert converts the raw value in unit 'from' into unit 'to'. The result is exact.
+func (r *Registry) Convert(value uint64, from, to string) (*big.Rat, error) {
+	factor, err := r.Factor(from, to)
+	if err != nil {
+		return nil, err
+	}
+	v := new(big.Rat).SetInt(new(big.Int).SetUint64(value))
+	return v.Mul(v, factor), nil
+}
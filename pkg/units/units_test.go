@@ -0,0 +1,52 @@
+package units
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	for name, expected := range map[string]Unit{
+		"B":      {Name: "B", Base: "B", Factor: big.NewRat(1, 1)},
+		"GB":     {Name: "GB", Base: "B", Factor: big.NewRat(1000000000, 1)},
+		"GiB":    {Name: "GiB", Base: "B", Factor: big.NewRat(1073741824, 1)},
+		"GBDay":  {Name: "GBDay", Base: "BDay", Factor: big.NewRat(1000000000, 1)},
+		"KiBDay": {Name: "KiBDay", Base: "BDay", Factor: big.NewRat(1024, 1)},
+		"KReq":   {Name: "KReq", Base: "Req", Factor: big.NewRat(1000, 1)},
+		"kReq":   {Name: "kReq", Base: "Req", Factor: big.NewRat(1000, 1)},
+		"IPDay":  {Name: "IPDay", Base: "IPDay", Factor: big.NewRat(1, 1)},
+	} {
+		unit, err := Default.Parse(name)
+		require.NoError(t, err, name)
+		assert.Equal(t, expected.Base, unit.Base, name)
+		assert.Equal(t, expected.Factor.String(), unit.Factor.String(), name)
+	}
+
+	for _, name := range []string{"", "G", "GX", "XB", "GiGB", "Gb"} {
+		_, err := Default.Parse(name)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	testConvert := func(value uint64, from, to, expected string) {
+		result, err := Default.Convert(value, from, to)
+		require.NoError(t, err, "%s -> %s", from, to)
+		assert.Equal(t, expected, result.RatString(), "%d %s -> %s", value, from, to)
+	}
+
+	testConvert(1500000000, "BDay", "GBDay", "3/2")
+	testConvert(1, "B", "GB", "1/1000000000")
+	testConvert(1073741824, "B", "GiB", "1")
+	testConvert(5, "Req", "KReq", "1/200")
+	testConvert(2, "KiB", "B", "2048")
+	testConvert(1, "GiB", "GB", "2097152/1953125")
+
+	_, err := Default.Convert(1, "B", "GBDay")
+	assert.Error(t, err)
+	_, err = Default.Convert(1, "Req", "GB")
+	assert.Error(t, err)
+}
@@ -0,0 +1,73 @@
+package usagemodel
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Usage is the sum of the quantities of all facts with the same tenant, category, query, product and discount.
+type Usage struct {
+	Tenant       string         `db:"tenant"`
+	TenantTarget sql.NullString `db:"tenant_target"`
+	// Category is the category source in the form of "zone:namespace".
+	Category string `db:"category"`
+
+	Query            string `db:"query"`
+	QueryDescription string `db:"query_description"`
+	Unit             string `db:"unit"`
+
+	Product       string         `db:"product"`
+	ProductTarget sql.NullString `db:"product_target"`
+	Amount        float64        `db:"amount"`
+	Discount      float64        `db:"discount"`
+
+	// Quantity is the exact decimal sum of the quantities, as summed up by the database.
+	Quantity string `db:"quantity"`
+}
+
+// Namespace returns the namespace part of the category.
+func (u Usage) Namespace() string {
+	_, namespace, found := strings.Cut(u.Category, ":")
+	if !found {
+		return u.Category
+	}
+	return namespace
+}
+
+// Get returns the usage of the given queries in the time range [from, to), ordered by tenant, category and query.
+func Get(ctx context.Context, tx *sqlx.Tx, from, to time.Time, queryNames []string) ([]Usage, error) {
+	if len(queryNames) == 0 {
+		return nil, nil
+	}
+	query, args, err := sqlx.In(`SELECT
+                  tenants.source AS tenant, tenants.target AS tenant_target, categories.source AS category,
+                  queries.name AS query, queries.description AS query_description, queries.unit AS unit,
+                  products.source AS product, products.target AS product_target, products.amount AS amount,
+                  discounts.discount AS discount, SUM(facts.quantity::numeric)::text AS quantity
+                  FROM facts
+                  INNER JOIN date_times ON facts.date_time_id = date_times.id
+                  INNER JOIN tenants ON facts.tenant_id = tenants.id
+                  INNER JOIN categories ON facts.category_id = categories.id
+                  INNER JOIN queries ON facts.query_id = queries.id
+                  INNER JOIN products ON facts.product_id = products.id
+                  INNER JOIN discounts ON facts.discount_id = discounts.id
+                  WHERE date_times.timestamp >= ? AND date_times.timestamp < ?
+                  AND queries.name IN (?)
+                  GROUP BY tenants.id, categories.id, queries.id, products.id, discounts.id
+                  ORDER BY tenants.source, categories.source, queries.name, products.source`,
+		from, to, queryNames)
+	if err != nil {
+		return nil, fmt.Errorf("cannot build usage query: %w", err)
+	}
+	var usage []Usage
+	err = sqlx.SelectContext(ctx, tx, &usage, tx.Rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get usage from %s to %s: %w", from, to, err)
+	}
+	return usage, nil
+}
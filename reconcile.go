@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+
+	"github.com/cloudscale-ch/cloudscale-go-sdk/v2"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/units"
+)
+
+// reconciliation compares the usage of all buckets of a cloudscale account to the usage attributed to tenants, for
+// one source query, zone (account) and day. Both values are raw values in the unit of the source query, see sourceUnits.
+type reconciliation struct {
+	Date       time.Time
+	Query      string
+	Zone       string
+	Total      uint64
+	Attributed uint64
+	// Unit is the unit of the query in the catalog, the unbilled usage is shown in it. It is the raw unit if empty.
+	Unit string
+}
+
+// Unbilled returns the usage which has not been attributed to any tenant.
+func (r reconciliation) Unbilled() uint64 {
+	if r.Attributed > r.Total {
+		return 0
+	}
+	return r.Total - r.Attributed
+}
+
+// AttributedPercentage returns the percentage of the total usage attributed to tenants. It is 100 if there is no usage.
+func (r reconciliation) AttributedPercentage() float64 {
+	if r.Total == 0 {
+		return 100
+	}
+	return float64(r.Attributed) / float64(r.Total) * 100
+}
+
+func (r reconciliation) String() string {
+	unbilled := new(big.Rat).SetUint64(r.Unbilled())
+	unit := sourceUnits[r.Query]
+	if r.Unit != "" {
+		if converted, err := units.Default.Convert(r.Unbilled(), unit, r.Unit); err == nil {
+			unbilled, unit = converted, r.Unit
+		}
+	}
+	return fmt.Sprintf("%s %s: %.2f%% attributed, %s %s unbilled", r.Date.Format("2006-01-02"), r.Query+":"+r.Zone, r.AttributedPercentage(), unbilled.FloatString(3), unit)
+}
+
+/*
+reconcile sums up the usage of all the buckets returned by cloudscale for the day, including the buckets which could not
+be attributed to a tenant, and compares it to the values accumulated for the zone of the account. The storage is
+weighted the same way as it is accumulated, see bucketStorage. The intervals accumulated separately with hourly
+granularity add up to the same storage, see intervalStorages.
+*/
+func reconcile(c *catalog, date time.Time, zone string, bucketMetrics *cloudscale.BucketMetrics, accumulated map[AccumulateKey]uint64) []reconciliation {
+	dayLength := date.AddDate(0, 0, 1).Sub(date)
+	totals := map[string]uint64{}
+	for _, bucketMetricsData := range bucketMetrics.Data {
+		totals[sourceQueryStorage] += bucketStorage(bucketMetricsData.TimeSeries, dayLength)
+		for _, interval := range bucketMetricsData.TimeSeries {
+			totals[sourceQueryTrafficOut] += uint64(interval.Usage.SentBytes)
+			totals[sourceQueryRequests] += uint64(interval.Usage.Requests)
+		}
+	}
+	attributed := map[string]uint64{}
+	for source, value := range accumulated {
+		if source.Zone != zone {
+			continue
+		}
+		attributed[source.Query] += value
+	}
+
+	queries := []string{sourceQueryStorage, sourceQueryTrafficOut, sourceQueryRequests}
+	reconciliations := make([]reconciliation, 0, len(queries))
+	for _, query := range queries {
+		r := reconciliation{Date: date, Query: query, Zone: zone, Total: totals[query], Attributed: attributed[query]}
+		if q := c.query(query + ":" + zone); q != nil {
+			r.Unit = q.Unit
+		}
+		reconciliations = append(reconciliations, r)
+	}
+	return reconciliations
+}
+
+// attribute returns the reconciliations with the usage attributed to tenants summed up from the values written, e.g.
+// by an output holding back facts, instead of the values accumulated.
+func attribute(reconciliations []reconciliation, written map[AccumulateKey]uint64) []reconciliation {
+	attributed := map[string]uint64{}
+	for source, value := range written {
+		attributed[source.Query+":"+source.Zone] += value
+	}
+	result := make([]reconciliation, len(reconciliations))
+	for i, r := range reconciliations {
+		r.Attributed = attributed[r.Query+":"+r.Zone]
+		result[i] = r
+	}
+	return result
+}
+
+// reportReconciliations writes the reconciliations of the values written by the output to w and exports them as
+// metrics.
+func reportReconciliations(w io.Writer, output string, reconciliations []reconciliation) {
+	for _, r := range reconciliations {
+		fmt.Fprintf(w, "reconciliation %s (output %s)\n", r, output)
+		accountUsageGauge.WithLabelValues(r.Query, r.Zone, sourceUnits[r.Query]).Set(float64(r.Total))
+		attributedUsageGauge.WithLabelValues(r.Query, r.Zone, sourceUnits[r.Query], output).Set(float64(r.Attributed))
+	}
+}
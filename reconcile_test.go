@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cloudscale-ch/cloudscale-go-sdk/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconcile(t *testing.T) {
+	date := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	usage := func(start, end time.Duration, storage, sent, requests int) cloudscale.BucketMetricsInterval {
+		return cloudscale.BucketMetricsInterval{
+			Start: date.Add(start),
+			End:   date.Add(end),
+			Usage: cloudscale.BucketMetricsIntervalUsage{StorageBytes: storage, SentBytes: sent, Requests: requests},
+		}
+	}
+	bucketMetrics := &cloudscale.BucketMetrics{Data: []cloudscale.BucketMetricsData{
+		{TimeSeries: []cloudscale.BucketMetricsInterval{usage(0, 24*time.Hour, 3000000000, 100, 10)}},
+		// not attributed to a tenant, the storage of the intervals is weighted by their share of the day
+		{TimeSeries: []cloudscale.BucketMetricsInterval{usage(0, 12*time.Hour, 1000000000, 0, 0), usage(12*time.Hour, 24*time.Hour, 1000000000, 0, 0)}},
+	}}
+	accumulated := map[AccumulateKey]uint64{
+		{Query: sourceQueryStorage, Zone: "cloudscale", Tenant: "inity", Namespace: "a", Start: date}:    1000000000,
+		{Query: sourceQueryStorage, Zone: "cloudscale", Tenant: "inity", Namespace: "b", Start: date}:    2000000000,
+		{Query: sourceQueryTrafficOut, Zone: "cloudscale", Tenant: "inity", Namespace: "a", Start: date}: 100,
+		// another account
+		{Query: sourceQueryRequests, Zone: "cloudscale-lpg", Tenant: "inity", Namespace: "a", Start: date}: 10,
+	}
+
+	reconciliations := reconcile(newCatalog([]string{defaultSourceZone}), date, "cloudscale", bucketMetrics, accumulated)
+	require.Len(t, reconciliations, 3)
+
+	storage := reconciliations[0]
+	assert.Equal(t, sourceQueryStorage, storage.Query)
+	assert.Equal(t, uint64(4000000000), storage.Total)
+	assert.Equal(t, uint64(1000000000), storage.Unbilled())
+	assert.Equal(t, 75.0, storage.AttributedPercentage())
+	assert.Equal(t, "2022-10-01 object-storage-storage:cloudscale: 75.00% attributed, 1.000 GBDay unbilled", storage.String())
+
+	assert.Equal(t, 100.0, reconciliations[1].AttributedPercentage())
+	assert.Equal(t, uint64(0), reconciliations[1].Unbilled())
+
+	requests := reconciliations[2]
+	assert.Equal(t, uint64(10), requests.Unbilled())
+	assert.Equal(t, 0.0, requests.AttributedPercentage())
+
+	assert.Equal(t, 100.0, reconciliation{}.AttributedPercentage(), "no usage is fully attributed")
+
+	// the fact of namespace b has been held back
+	written := map[AccumulateKey]uint64{
+		{Query: sourceQueryStorage, Zone: "cloudscale", Tenant: "inity", Namespace: "a", Start: date}:      1000000000,
+		{Query: sourceQueryTrafficOut, Zone: "cloudscale", Tenant: "inity", Namespace: "a", Start: date}:   100,
+		{Query: sourceQueryRequests, Zone: "cloudscale-lpg", Tenant: "inity", Namespace: "a", Start: date}: 10,
+	}
+	attributed := attribute(reconciliations, written)
+	require.Len(t, attributed, 3)
+	assert.Equal(t, uint64(4000000000), attributed[0].Total)
+	assert.Equal(t, uint64(1000000000), attributed[0].Attributed, "only the written facts are attributed")
+	assert.Equal(t, uint64(100), attributed[1].Attributed)
+	assert.Equal(t, uint64(0), attributed[2].Attributed, "facts of other accounts are not attributed")
+	assert.Equal(t, uint64(3000000000), reconciliations[0].Attributed, "the reconciliations are not modified")
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"math/big"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/vshn/cloudscale-metrics-collector/pkg/units"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/usagemodel"
+)
+
+// chfRounding is used to display amounts in CHF. Calculations are done with the exact amounts.
+var chfRounding = units.RoundingPolicy{Mode: units.RoundHalfUp, Decimals: 2}
+
+// reportLine is the usage of a product in a namespace.
+type reportLine struct {
+	Namespace   string
+	Query       string
+	Product     string
+	Description string
+	Unit        string
+	Quantity    *big.Rat
+	Price       *big.Rat
+	Discount    *big.Rat
+	// Total is Quantity * Price * (1 - Discount).
+	Total *big.Rat
+}
+
+// tenantReport is the usage of a tenant.
+type tenantReport struct {
+	Tenant string
+	Lines  []reportLine
+	Total  *big.Rat
+}
+
+// report is the usage of all tenants in the period [From, To).
+type report struct {
+	From    time.Time
+	To      time.Time
+	Tenants []tenantReport
+	Total   *big.Rat
+}
+
+/*
+reportCommand renders a preview of what the tenants will be charged for the usage collected by this collector, with
+the amounts of the products and discounts applied. The report covers the given month (default: the current month)
+or the days between -from and -to, both inclusive.
+*/
+func reportCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("report", flag.ContinueOnError)
+	monthStr := flags.String("month", "", "month to report in the form YYYY-MM (default: current month)")
+	fromStr := flags.String("from", "", "first day to report in the form YYYY-MM-DD, instead of -month")
+	toStr := flags.String("to", "", "last day to report in the form YYYY-MM-DD, instead of -month")
+	format := flags.String("format", "text", "output format: text, html or csv")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	catalog, err := loadCatalog()
+	if err != nil {
+		return err
+	}
+
+	from, to, err := parsePeriod(*monthStr, *fromStr, *toStr)
+	if err != nil {
+		return err
+	}
+
+	dbUrl, err := getenvSecret(dbUrlEnvVariable)
+	if err != nil {
+		return err
+	}
+	if dbUrl == "" {
+		return fmt.Errorf("missing env var %q", dbUrlEnvVariable)
+	}
+	rdb, err := openDatabase(dbUrl)
+	if err != nil {
+		return err
+	}
+	defer rdb.Close()
+	tx, err := rdb.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	usage, err := usagemodel.Get(ctx, tx, from, to, catalog.queryNames())
+	if err != nil {
+		return err
+	}
+
+	r, err := buildReport(usage, from, to)
+	if err != nil {
+		return err
+	}
+	return renderReport(os.Stdout, *format, r)
+}
+
+// parsePeriod returns the period [from, to) in Europe/Zurich, either for the given month or the given days.
+func parsePeriod(month, fromDay, toDay string) (time.Time, time.Time, error) {
+	if fromDay == "" && toDay == "" {
+		if month == "" {
+			location, err := time.LoadLocation("Europe/Zurich")
+			if err != nil {
+				return time.Time{}, time.Time{}, err
+			}
+			month = time.Now().In(location).Format("2006-01")
+		}
+		from, err := parseMonth(month)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		return from, from.AddDate(0, 1, 0), nil
+	}
+	if month != "" || fromDay == "" || toDay == "" {
+		return time.Time{}, time.Time{}, fmt.Errorf("either -month or both -from and -to must be set")
+	}
+
+	location, err := time.LoadLocation("Europe/Zurich")
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	from, err := time.ParseInLocation("2006-01-02", fromDay, location)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid day %q: %w", fromDay, err)
+	}
+	to, err := time.ParseInLocation("2006-01-02", toDay, location)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid day %q: %w", toDay, err)
+	}
+	if to.Before(from) {
+		return time.Time{}, time.Time{}, fmt.Errorf("-to %s is before -from %s", toDay, fromDay)
+	}
+	return from, to.AddDate(0, 0, 1), nil
+}
+
+// buildReport calculates the amounts of the usage. The usage must be ordered by tenant, as returned by usagemodel.Get.
+func buildReport(usage []usagemodel.Usage, from, to time.Time) (*report, error) {
+	r := &report{From: from, To: to, Total: new(big.Rat)}
+	for _, u := range usage {
+		quantity, ok := new(big.Rat).SetString(u.Quantity)
+		if !ok {
+			return nil, fmt.Errorf("invalid quantity %q of %s for tenant %q", u.Quantity, u.Query, u.Tenant)
+		}
+		price := units.FromFloat(u.Amount)
+		discount := units.FromFloat(u.Discount)
+		total := new(big.Rat).Mul(quantity, price)
+		total.Mul(total, new(big.Rat).Sub(big.NewRat(1, 1), discount))
+
+		if len(r.Tenants) == 0 || r.Tenants[len(r.Tenants)-1].Tenant != u.Tenant {
+			r.Tenants = append(r.Tenants, tenantReport{Tenant: u.Tenant, Total: new(big.Rat)})
+		}
+		tenant := &r.Tenants[len(r.Tenants)-1]
+		tenant.Lines = append(tenant.Lines, reportLine{
+			Namespace:   u.Namespace(),
+			Query:       u.Query,
+			Product:     u.Product,
+			Description: u.QueryDescription,
+			Unit:        u.Unit,
+			Quantity:    quantity,
+			Price:       price,
+			Discount:    discount,
+			Total:       total,
+		})
+		tenant.Total.Add(tenant.Total, total)
+		r.Total.Add(r.Total, total)
+	}
+	return r, nil
+}
+
+func renderReport(w io.Writer, format string, r *report) error {
+	switch format {
+	case "text":
+		return renderReportText(w, r)
+	case "csv":
+		return renderReportCSV(w, r)
+	case "html":
+		return reportHTMLTemplate.Execute(w, r)
+	}
+	return fmt.Errorf("unknown format %q, must be one of: text, html, csv", format)
+}
+
+// Period returns the period of the report with the last day inclusive.
+func (r *report) Period() string {
+	return r.From.Format("2006-01-02") + " - " + r.To.AddDate(0, 0, -1).Format("2006-01-02")
+}
+
+func renderReportText(w io.Writer, r *report) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Usage %s\n", r.Period())
+	for _, tenant := range r.Tenants {
+		fmt.Fprintf(tw, "\nTenant %s\t\t\t\t\t\t\n", tenant.Tenant)
+		fmt.Fprintf(tw, "Namespace\tProduct\tQuantity\tUnit\tPrice\tDiscount\tTotal CHF\t\n")
+		for _, line := range tenant.Lines {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n", line.Namespace, line.Description, units.FormatDecimal(line.Quantity), line.Unit,
+				units.FormatDecimal(line.Price), units.FormatDecimal(line.Discount), chfRounding.Round(line.Total).FloatString(2))
+		}
+		fmt.Fprintf(tw, "Total %s\t\t\t\t\t\t%s\t\n", tenant.Tenant, chfRounding.Round(tenant.Total).FloatString(2))
+	}
+	fmt.Fprintf(tw, "\nTotal\t\t\t\t\t\t%s\t\n", chfRounding.Round(r.Total).FloatString(2))
+	return tw.Flush()
+}
+
+func renderReportCSV(w io.Writer, r *report) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write([]string{"from", "to", "tenant", "namespace", "product", "description", "quantity", "unit", "price", "discount", "total"})
+	if err != nil {
+		return err
+	}
+	from := r.From.Format("2006-01-02")
+	to := r.To.AddDate(0, 0, -1).Format("2006-01-02")
+	for _, tenant := range r.Tenants {
+		for _, line := range tenant.Lines {
+			err := cw.Write([]string{from, to, tenant.Tenant, line.Namespace, line.Product, line.Description, units.FormatDecimal(line.Quantity), line.Unit,
+				units.FormatDecimal(line.Price), units.FormatDecimal(line.Discount), units.FormatDecimal(line.Total)})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+var reportHTMLTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"decimal": units.FormatDecimal,
+	"chf":     func(r *big.Rat) string { return chfRounding.Round(r).FloatString(2) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Usage {{ .Period }}</title></head>
+<body>
+<h1>Usage {{ .Period }}</h1>
+{{- range .Tenants }}
+<h2>Tenant {{ .Tenant }}</h2>
+<table>
+<tr><th>Namespace</th><th>Product</th><th>Quantity</th><th>Unit</th><th>Price</th><th>Discount</th><th>Total CHF</th></tr>
+{{- range .Lines }}
+<tr><td>{{ .Namespace }}</td><td>{{ .Description }}</td><td>{{ decimal .Quantity }}</td><td>{{ .Unit }}</td><td>{{ decimal .Price }}</td><td>{{ decimal .Discount }}</td><td>{{ chf .Total }}</td></tr>
+{{- end }}
+<tr><th colspan="6">Total {{ .Tenant }}</th><th>{{ chf .Total }}</th></tr>
+</table>
+{{- end }}
+<p><strong>Total CHF {{ chf .Total }}</strong></p>
+</body>
+</html>
+`))
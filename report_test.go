@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/usagemodel"
+)
+
+func TestReport(t *testing.T) {
+	from, to, err := parsePeriod("2022-10", "", "")
+	require.NoError(t, err)
+	assert.Equal(t, "2022-10-01 - 2022-10-31", (&report{From: from, To: to}).Period())
+
+	usage := []usagemodel.Usage{
+		{
+			Tenant: "inity", Category: "cloudscale:testnamespace", Query: "object-storage-storage:cloudscale",
+			QueryDescription: "Object Storage - Storage (cloudscale.ch)", Unit: "GBDay", Product: "object-storage-storage:cloudscale",
+			Amount: 0.0033, Discount: 0, Quantity: "1000",
+		},
+		{
+			Tenant: "inity", Category: "cloudscale:testnamespace", Query: "object-storage-traffic-out:cloudscale",
+			QueryDescription: "Object Storage - Traffic Out (cloudscale.ch)", Unit: "GB", Product: "object-storage-traffic-out:cloudscale",
+			Amount: 0.022, Discount: 0.5, Quantity: "10.5",
+		},
+		{
+			Tenant: "vshn", Category: "cloudscale:other", Query: "object-storage-requests:cloudscale",
+			QueryDescription: "Object Storage - Requests (cloudscale.ch)", Unit: "KReq", Product: "object-storage-requests:cloudscale",
+			Amount: 0.0055, Discount: 0, Quantity: "3",
+		},
+	}
+	r, err := buildReport(usage, from, to)
+	require.NoError(t, err)
+	require.Len(t, r.Tenants, 2)
+	assert.Equal(t, "inity", r.Tenants[0].Tenant)
+	assert.Len(t, r.Tenants[0].Lines, 2)
+	assert.Equal(t, "3.4155", r.Tenants[0].Total.FloatString(4))
+	assert.Equal(t, "0.0165", r.Tenants[1].Total.FloatString(4))
+	assert.Equal(t, "3.432", r.Total.FloatString(3))
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, renderReport(buf, "text", r))
+	assert.Contains(t, buf.String(), "Usage 2022-10-01 - 2022-10-31")
+	assert.Regexp(t, `Total inity\s+3\.42`, buf.String())
+	assert.Regexp(t, `\nTotal\s+3\.43`, buf.String())
+
+	buf.Reset()
+	require.NoError(t, renderReport(buf, "csv", r))
+	assert.Contains(t, buf.String(), "2022-10-01,2022-10-31,inity,testnamespace,object-storage-traffic-out:cloudscale,Object Storage - Traffic Out (cloudscale.ch),10.5,GB,0.022,0.5,0.1155\n")
+
+	buf.Reset()
+	require.NoError(t, renderReport(buf, "html", r))
+	assert.Contains(t, buf.String(), "<h2>Tenant vshn</h2>")
+	assert.Contains(t, buf.String(), "<strong>Total CHF 3.43</strong>")
+
+	assert.Error(t, renderReport(buf, "pdf", r))
+}
+
+func TestParsePeriod(t *testing.T) {
+	from, to, err := parsePeriod("", "2022-10-03", "2022-10-05")
+	require.NoError(t, err)
+	assert.Equal(t, "2022-10-03", from.Format("2006-01-02"))
+	assert.Equal(t, 2*24*time.Hour+24*time.Hour, to.Sub(from))
+
+	_, _, err = parsePeriod("2022-10", "2022-10-03", "2022-10-05")
+	assert.Error(t, err)
+	_, _, err = parsePeriod("", "2022-10-03", "")
+	assert.Error(t, err)
+	_, _, err = parsePeriod("", "2022-10-05", "2022-10-03")
+	assert.Error(t, err)
+}
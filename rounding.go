@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/units"
+)
+
+// roundingPolicies holds the rounding policy applied to the quantity of every fact, with overrides per product.
+type roundingPolicies struct {
+	defaultPolicy units.RoundingPolicy
+	// products maps product sources (e.g. "object-storage-storage:cloudscale") to their rounding policy.
+	products map[string]units.RoundingPolicy
+}
+
+/*
+parseRoundingPolicies parses a comma separated list of rounding policies. An entry is either a policy which is used
+as the default (e.g. "half-up:6"), or a product source and a policy separated by "=" (e.g.
+"object-storage-traffic-out:cloudscale=up:0" to bill every started GB). Without a default, quantities are not rounded.
+There can only be one default and one policy per product. The products are checked against the catalog separately, see
+checkProducts.
+*/
+func parseRoundingPolicies(s string) (*roundingPolicies, error) {
+	policies := &roundingPolicies{
+		defaultPolicy: units.NoRounding,
+		products:      map[string]units.RoundingPolicy{},
+	}
+	if s == "" {
+		return policies, nil
+	}
+	hasDefault := false
+	for _, entry := range strings.Split(s, ",") {
+		product, policyStr, isProduct := strings.Cut(strings.TrimSpace(entry), "=")
+		if !isProduct {
+			policyStr = product
+		}
+		policy, err := units.ParseRoundingPolicy(policyStr)
+		if err != nil {
+			return nil, err
+		}
+		if isProduct {
+			if _, ok := policies.products[product]; ok {
+				return nil, fmt.Errorf("more than one rounding policy for product %q", product)
+			}
+			policies.products[product] = policy
+		} else {
+			if hasDefault {
+				return nil, fmt.Errorf("more than one default rounding policy")
+			}
+			policies.defaultPolicy = policy
+			hasDefault = true
+		}
+	}
+	return policies, nil
+}
+
+// checkProducts returns an error if there are policies for products which are neither one of the given products nor the
+// fallback product, e.g. because of a typo, which would leave the quantities of the intended product unrounded.
+func (p *roundingPolicies) checkProducts(products []*db.Product, fallback string) error {
+	known := map[string]bool{fallback: fallback != ""}
+	for _, product := range products {
+		known[product.Source] = true
+	}
+	var unknown []string
+	for product := range p.products {
+		if !known[product] {
+			unknown = append(unknown, product)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("rounding policies for unknown products %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// forProduct returns the rounding policy for the product with the given source.
+func (p *roundingPolicies) forProduct(source string) units.RoundingPolicy {
+	if policy, ok := p.products[source]; ok {
+		return policy
+	}
+	return p.defaultPolicy
+}
+
+/*
+checkHourly returns an error if any of the policies rounds up or down. With hourly granularity, the policies are applied
+to every fact of an interval, e.g. to the 1/24 of the daily storage. Rounding every started GB up would bill up to 25
+GB a day for a single byte. Rounding to the nearest value is allowed, but its error also adds up over the facts of a
+day.
+*/
+func (p *roundingPolicies) checkHourly() error {
+	if err := checkHourlyPolicy(p.defaultPolicy); err != nil {
+		return err
+	}
+	products := make([]string, 0, len(p.products))
+	for product := range p.products {
+		products = append(products, product)
+	}
+	sort.Strings(products)
+	for _, product := range products {
+		if err := checkHourlyPolicy(p.products[product]); err != nil {
+			return fmt.Errorf("product %s: %w", product, err)
+		}
+	}
+	return nil
+}
+
+func checkHourlyPolicy(policy units.RoundingPolicy) error {
+	if policy.Mode == units.RoundUp || policy.Mode == units.RoundDown {
+		return fmt.Errorf("rounding policy %s rounds every hourly fact in one direction, use %s or %s with hourly granularity", policy, units.RoundHalfUp, units.RoundHalfEven)
+	}
+	return nil
+}
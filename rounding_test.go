@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/units"
+)
+
+func TestParseRoundingPolicies(t *testing.T) {
+	policies, err := parseRoundingPolicies("")
+	require.NoError(t, err)
+	assert.Equal(t, units.NoRounding, policies.forProduct("object-storage-storage:cloudscale"))
+
+	policies, err = parseRoundingPolicies("half-up:6, object-storage-traffic-out:cloudscale=up:0")
+	require.NoError(t, err)
+	assert.Equal(t, units.RoundingPolicy{Mode: units.RoundHalfUp, Decimals: 6}, policies.forProduct("object-storage-storage:cloudscale"))
+	assert.Equal(t, units.RoundingPolicy{Mode: units.RoundUp, Decimals: 0}, policies.forProduct("object-storage-traffic-out:cloudscale"))
+
+	_, err = parseRoundingPolicies("half-up:6,object-storage-storage:cloudscale=nearest")
+	assert.Error(t, err)
+	_, err = parseRoundingPolicies("half-up:6,object-storage-storage:cloudscale=up:0,object-storage-storage:cloudscale=down:0")
+	assert.EqualError(t, err, `more than one rounding policy for product "object-storage-storage:cloudscale"`)
+	_, err = parseRoundingPolicies("half-up:6,half-even:2")
+	assert.EqualError(t, err, "more than one default rounding policy")
+}
+
+func TestCheckProducts(t *testing.T) {
+	products := []*db.Product{{Source: "object-storage-storage:cloudscale"}, {Source: "object-storage-traffic-out:cloudscale"}}
+	policies, err := parseRoundingPolicies("half-up:6,object-storage-storage:cloudscale=up:0,object-storage-unpriced=none")
+	require.NoError(t, err)
+	assert.NoError(t, policies.checkProducts(products, "object-storage-unpriced"))
+	assert.EqualError(t, policies.checkProducts(products, ""), "rounding policies for unknown products object-storage-unpriced")
+
+	policies, err = parseRoundingPolicies("object-storage-trafic-out:cloudscale=up:0,object-storage-storage:cloudscale-lpg=up:0")
+	require.NoError(t, err)
+	assert.EqualError(t, policies.checkProducts(products, ""), "rounding policies for unknown products object-storage-storage:cloudscale-lpg, object-storage-trafic-out:cloudscale")
+}
+
+func TestCheckHourly(t *testing.T) {
+	policies, err := parseRoundingPolicies("half-up:6,object-storage-storage:cloudscale=half-even:3")
+	require.NoError(t, err)
+	assert.NoError(t, policies.checkHourly())
+
+	policies, err = parseRoundingPolicies("half-up:6,object-storage-traffic-out:cloudscale=up:0")
+	require.NoError(t, err)
+	assert.EqualError(t, policies.checkHourly(), "product object-storage-traffic-out:cloudscale: rounding policy up:0 rounds every hourly fact in one direction, use half-up or half-even with hourly granularity")
+
+	policies, err = parseRoundingPolicies("down:2")
+	require.NoError(t, err)
+	assert.Error(t, policies.checkHourly())
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+	"github.com/jmoiron/sqlx"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/correctionsmodel"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/runsmodel"
+)
+
+// run holds the state of a single collector run, which may cover multiple days.
+// The run is recorded in the collector_runs table, together with the version of the collector.
+type run struct {
+	runsmodel.Run
+
+	days        []string
+	pendingDays []string
+	corrections []*correctionsmodel.Correction
+	anomalies   []usageAnomaly
+}
+
+func newRun() *run {
+	return &run{Run: runsmodel.Run{
+		Version:   version,
+		Commit:    commit,
+		StartedAt: time.Now(),
+		Status:    runsmodel.StatusRunning,
+	}}
+}
+
+// start records the run in the database, which generates its id.
+func (r *run) start(ctx context.Context, rdb *sqlx.DB) error {
+	tx, err := rdb.BeginTxx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	created, err := runsmodel.Create(tx, &r.Run)
+	if err != nil {
+		return err
+	}
+	r.Id = created.Id
+	return tx.Commit()
+}
+
+// finish records the outcome of the run in the database. runErr is the error the run failed with, if any.
+func (r *run) finish(ctx context.Context, rdb *sqlx.DB, runErr error) error {
+	r.FinishedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	r.Days = strings.Join(r.days, ",")
+	r.PendingDays = strings.Join(r.pendingDays, ",")
+	r.Status = runsmodel.StatusSucceeded
+	if runErr != nil {
+		r.Status = runsmodel.StatusFailed
+		r.Error = runErr.Error()
+	}
+
+	tx, err := rdb.BeginTxx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = runsmodel.Update(tx, &r.Run)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// writtenFact is a fact written within a transaction, together with the fact it replaced, if any, and the correction
+// recorded for it. It is counted by the run once the transaction has been committed, see countFact.
+type writtenFact struct {
+	previous   *db.Fact
+	fact       *db.Fact
+	correction *correctionsmodel.Correction
+}
+
+// factChanged returns whether the fact is new or its quantity differs from the previous fact, i.e. whether it is
+// written by the run and must be linked to it.
+func factChanged(previous, fact *db.Fact) bool {
+	return previous == nil || previous.Quantity != fact.Quantity
+}
+
+// countFact counts the fact as created if there is no previous fact, or as updated if its quantity changed. It must
+// only be called once the fact has been committed.
+func (r *run) countFact(w writtenFact) {
+	if w.fact == nil || !factChanged(w.previous, w.fact) {
+		return
+	}
+	if w.previous == nil {
+		r.FactsCreated++
+	} else {
+		r.FactsUpdated++
+	}
+	if w.correction != nil {
+		r.corrections = append(r.corrections, w.correction)
+	}
+}
+
+// printSummary writes a summary of the run to w, listing all corrected facts with their deltas. The corrections of a
+// failed run are listed as well.
+func (r *run) printSummary(w io.Writer) {
+	fmt.Fprintf(w, "run %s: %d facts created, %d facts updated\n", r.Id, r.FactsCreated, r.FactsUpdated)
+	if r.Status == runsmodel.StatusFailed {
+		fmt.Fprintf(w, "  failed: %s\n", r.Error)
+	}
+	if len(r.pendingDays) > 0 {
+		fmt.Fprintf(w, "  pending days: %s\n", strings.Join(r.pendingDays, ", "))
+	}
+	if len(r.anomalies) > 0 {
+		fmt.Fprintf(w, "  %d anomalies, %d facts held back\n", len(r.anomalies), r.FactsHeld)
+		for _, a := range r.anomalies {
+			held := ""
+			if a.Held {
+				held = " (held back)"
+			}
+			fmt.Fprintf(w, "  %s%s\n", a, held)
+		}
+	}
+	if len(r.corrections) == 0 {
+		fmt.Fprintf(w, "  no facts corrected\n")
+		return
+	}
+	fmt.Fprintf(w, "  corrected %d facts\n", len(r.corrections))
+	for _, c := range r.corrections {
+		fmt.Fprintf(w, "  %s %s: %g -> %g (%+g)\n", c.Timestamp.Format("2006-01-02"), c.Source, c.OldQuantity, c.NewQuantity, c.Delta())
+	}
+}
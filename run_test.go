@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/anomaly"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/correctionsmodel"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/runsmodel"
+)
+
+func TestPrintSummary(t *testing.T) {
+	date := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	r := &run{Run: runsmodel.Run{Id: "run-1", FactsCreated: 3, FactsUpdated: 2}}
+	var b strings.Builder
+	r.printSummary(&b)
+	assert.Equal(t, "run run-1: 3 facts created, 2 facts updated\n  no facts corrected\n", b.String())
+
+	source := AccumulateKey{Query: sourceQueryStorage, Zone: "cloudscale", Tenant: "inity", Namespace: "ns", Start: date}
+	r.Status = runsmodel.StatusFailed
+	r.Error = "output db: connection lost"
+	r.FactsHeld = 1
+	r.pendingDays = []string{"2022-10-02", "2022-10-03"}
+	r.anomalies = []usageAnomaly{
+		{Source: source, Quantity: 30, Result: anomaly.Result{Baseline: 10, Ratio: 3, Anomalous: true}, Held: true},
+	}
+	r.corrections = []*correctionsmodel.Correction{
+		{Source: source.String(), Timestamp: date, OldQuantity: 1.5, NewQuantity: 2},
+		{Source: source.String(), Timestamp: date.AddDate(0, 0, -1), OldQuantity: 2, NewQuantity: 0.25},
+	}
+	b.Reset()
+	r.printSummary(&b)
+	assert.Equal(t, `run run-1: 3 facts created, 2 facts updated
+  failed: output db: connection lost
+  pending days: 2022-10-02, 2022-10-03
+  1 anomalies, 1 facts held back
+  2022-10-01 object-storage-storage:cloudscale:inity:ns: quantity 30 is 3.00x the baseline 10 (held back)
+  corrected 2 facts
+  2022-10-01 object-storage-storage:cloudscale:inity:ns: 1.5 -> 2 (+0.5)
+  2022-09-30 object-storage-storage:cloudscale:inity:ns: 2 -> 0.25 (-1.75)
+`, b.String())
+}
+
+func TestCountFact(t *testing.T) {
+	assert.True(t, factChanged(nil, &db.Fact{Quantity: 1}), "new fact")
+	assert.True(t, factChanged(&db.Fact{Quantity: 1}, &db.Fact{Quantity: 2}), "changed quantity")
+	assert.False(t, factChanged(&db.Fact{Quantity: 2}, &db.Fact{Quantity: 2}), "unchanged quantity")
+
+	r := &run{}
+	correction := &correctionsmodel.Correction{OldQuantity: 1, NewQuantity: 2}
+	r.countFact(writtenFact{fact: &db.Fact{Quantity: 1}})
+	r.countFact(writtenFact{previous: &db.Fact{Quantity: 1}, fact: &db.Fact{Quantity: 2}, correction: correction})
+	r.countFact(writtenFact{previous: &db.Fact{Quantity: 2}, fact: &db.Fact{Quantity: 2}})
+	r.countFact(writtenFact{})
+	assert.Equal(t, 1, r.FactsCreated)
+	assert.Equal(t, 1, r.FactsUpdated)
+	assert.Equal(t, []*correctionsmodel.Correction{correction}, r.corrections)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	// aliased, as sync is the name of the sync command
+	gosync "sync"
+	"time"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+	"github.com/jackc/pgx/v4/stdlib"
+	"github.com/jmoiron/sqlx"
+)
+
+// secretFileSuffix is appended to the name of an env var to read its value from a file instead, e.g.
+// CLOUDSCALE_API_TOKEN_FILE=/secrets/cloudscale/token.
+const secretFileSuffix = "_FILE"
+
+// getenvSecret returns the value of the env var, or the content of the file named by the env var with the _FILE
+// suffix. Setting both is an error. Trailing newlines of the file are removed.
+func getenvSecret(name string) (string, error) {
+	value := os.Getenv(name)
+	file := os.Getenv(name + secretFileSuffix)
+	if file == "" {
+		return value, nil
+	}
+	if value != "" {
+		return "", fmt.Errorf("only one of env vars %q and %q can be set", name, name+secretFileSuffix)
+	}
+	return newSecretFile(file).Value()
+}
+
+// secretFile is a secret mounted as file. The file is read again whenever it changed, so rotated secrets are picked up
+// without a restart.
+type secretFile struct {
+	path string
+
+	mu      gosync.Mutex
+	modTime time.Time
+	size    int64
+	value   string
+}
+
+func newSecretFile(path string) *secretFile {
+	return &secretFile{path: path}
+}
+
+// Value returns the content of the file without trailing newlines.
+func (f *secretFile) Value() (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return "", fmt.Errorf("cannot read secret: %w", err)
+	}
+	if f.value != "" && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.value, nil
+	}
+	content, err := os.ReadFile(f.path)
+	if err != nil {
+		return "", fmt.Errorf("cannot read secret: %w", err)
+	}
+	value := strings.TrimRight(string(content), "\r\n")
+	if value == "" {
+		return "", fmt.Errorf("secret file %s is empty", f.path)
+	}
+	f.value, f.modTime, f.size = value, info.ModTime(), info.Size()
+	return value, nil
+}
+
+// tokenFileTransport sets the bearer token read from a secret file on every request.
+type tokenFileTransport struct {
+	token *secretFile
+	base  http.RoundTripper
+}
+
+func (t *tokenFileTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.token.Value()
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return t.base.RoundTrip(req)
+}
+
+/*
+openDatabase opens the database at dbUrl. If the URL is read from a file (ACR_DB_URL_FILE), the file is read again
+for every new connection instead, so a rotated password is picked up by a running collector. Open connections keep
+working, as the password is only checked when connecting.
+*/
+func openDatabase(dbUrl string) (*sqlx.DB, error) {
+	file := os.Getenv(dbUrlEnvVariable + secretFileSuffix)
+	if file == "" {
+		return db.Openx(dbUrl)
+	}
+	return db.NewDBx(sql.OpenDB(&secretFileConnector{url: newSecretFile(file), driver: stdlib.GetDefaultDriver()})), nil
+}
+
+// secretFileConnector opens connections with the URL read from a secret file.
+type secretFileConnector struct {
+	url    *secretFile
+	driver driver.Driver
+}
+
+func (c *secretFileConnector) Connect(_ context.Context) (driver.Conn, error) {
+	url, err := c.url.Value()
+	if err != nil {
+		return nil, err
+	}
+	return c.driver.Open(url)
+}
+
+func (c *secretFileConnector) Driver() driver.Driver {
+	return c.driver
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+)
+
+// Sink is an output the collected usage is written to. Multiple sinks can be enabled at the same time, every one of
+// them gets the same accumulated values.
+type Sink interface {
+	// Name returns the name of the sink as used in the OUTPUT env var.
+	Name() string
+	// Open prepares the sink before the first day is written, e.g. connects to the database.
+	Open(ctx context.Context) error
+	// Write writes the values accumulated for the day starting at date.
+	Write(ctx context.Context, date time.Time, accumulated map[AccumulateKey]uint64) error
+	// Close finishes the sink after the last day has been written. pendingDays are the days which have been deferred
+	// because they were not complete yet, runErr is the error the run failed with, if any.
+	Close(ctx context.Context, pendingDays []time.Time, runErr error) error
+}
+
+// writtenSink is implemented by sinks which may write less than the accumulated values, e.g. because facts are held
+// back. Other sinks write all or nothing.
+type writtenSink interface {
+	// Written returns the values written by the last call to Write.
+	Written() map[AccumulateKey]uint64
+}
+
+// catchUpSink is implemented by sinks which know which days are missing, see findMissingDays.
+type catchUpSink interface {
+	MissingDays(ctx context.Context, date time.Time, days int) ([]time.Time, error)
+}
+
+// newSinks returns the sinks configured in cfg.outputs. The outputs of usage records resolve the fallback product from
+// the catalog, the db output from the database.
+func newSinks(cfg *config) ([]Sink, error) {
+	sinks := make([]Sink, 0, len(cfg.outputs))
+	var fallback *db.Product
+	for _, output := range cfg.outputs {
+		if output != outputDB && cfg.fallbackProduct != "" && fallback == nil {
+			var err error
+			fallback, err = cfg.catalog.fallbackProduct(cfg.fallbackProduct)
+			if err != nil {
+				return nil, fmt.Errorf("output %s: %w", output, err)
+			}
+		}
+		switch output {
+		case outputDB:
+			sinks = append(sinks, newDBSink(cfg))
+		case outputCSV, outputJSONL:
+			sinks = append(sinks, newFileSink(output, cfg.outputFile, cfg.catalog, cfg.rounding, fallback))
+		case outputWebhook:
+			sinks = append(sinks, newWebhookSink(cfg.webhookURL, cfg.catalog, cfg.rounding, fallback))
+		case outputAlerts:
+			sinks = append(sinks, newAlertSink(cfg, fallback))
+		default:
+			return nil, fmt.Errorf("unknown output %q", output)
+		}
+	}
+	return sinks, nil
+}
+
+// collect collects the day starting at date and writes it to all the sinks. If enabled, the days missing before date
+// are collected as well.
+func collect(ctx context.Context, sinks []Sink, date time.Time, accounts []account, clusters []cluster, cfg *config) error {
+	for i, sink := range sinks {
+		if err := sink.Open(ctx); err != nil {
+			err = fmt.Errorf("output %s: %w", sink.Name(), err)
+			closeSinks(ctx, sinks[:i+1], nil, err)
+			return err
+		}
+	}
+
+	pendingDays, runErr := collectDays(ctx, sinks, date, accounts, clusters, cfg)
+	closeErr := closeSinks(ctx, sinks, pendingDays, runErr)
+	if runErr != nil {
+		return runErr
+	}
+	return closeErr
+}
+
+// collectDays collects all days and writes them to the sinks. It returns the days that have been deferred. Without
+// catch-up, deferred days would never be collected again, so the run fails if there are any.
+func collectDays(ctx context.Context, sinks []Sink, date time.Time, accounts []account, clusters []cluster, cfg *config) ([]time.Time, error) {
+	dates := []time.Time{date}
+	catchingUp := false
+	if cfg.catchupDays > 0 {
+		for _, sink := range sinks {
+			catchUp, ok := sink.(catchUpSink)
+			if !ok {
+				continue
+			}
+			missing, err := catchUp.MissingDays(ctx, date, cfg.catchupDays)
+			if err != nil {
+				return nil, fmt.Errorf("catch-up: %w", err)
+			}
+			for _, day := range missing {
+				fmt.Fprintf(os.Stderr, "catching up on missing day %s\n", day.Format("2006-01-02"))
+			}
+			dates = append(missing, date)
+			catchingUp = true
+			break
+		}
+	}
+
+	var pendingDays []time.Time
+	for _, date := range dates {
+		accumulated, reconciliations, err := accumulateBucketMetrics(ctx, date, accounts, clusters, cfg)
+		var pending *pendingError
+		if errors.As(err, &pending) {
+			// Don't write partial numbers. The day will be collected again by a later run catching up.
+			fmt.Fprintf(os.Stderr, "PENDING: %v\n", pending)
+			pendingDays = append(pendingDays, date)
+			continue
+		}
+		if err != nil {
+			return pendingDays, err
+		}
+		var outputs []string
+		for _, sink := range sinks {
+			if err := sink.Write(ctx, date, accumulated); err != nil {
+				return pendingDays, &outputError{Output: sink.Name(), Date: date, Written: outputs, Err: err}
+			}
+			outputs = append(outputs, sink.Name())
+			written := accumulated
+			if w, ok := sink.(writtenSink); ok {
+				written = w.Written()
+			}
+			reportReconciliations(os.Stderr, sink.Name(), attribute(reconciliations, written))
+		}
+	}
+	if len(pendingDays) > 0 && !catchingUp {
+		return pendingDays, fmt.Errorf("%d pending days will not be collected again without %s, rerun the collector for them", len(pendingDays), catchupDaysEnvVariable)
+	}
+	return pendingDays, nil
+}
+
+// outputError is returned if writing a day to an output failed. The day may have been written to other outputs already,
+// which is part of the error recorded for the run.
+type outputError struct {
+	Output string
+	Date   time.Time
+	// Written are the outputs the day has been written to before
+	Written []string
+	Err     error
+}
+
+func (e *outputError) Error() string {
+	msg := fmt.Sprintf("output %s: %v", e.Output, e.Err)
+	if len(e.Written) > 0 {
+		msg += fmt.Sprintf(" (day %s has been written to %s already)", e.Date.Format("2006-01-02"), strings.Join(e.Written, ", "))
+	}
+	return msg
+}
+
+func (e *outputError) Unwrap() error {
+	return e.Err
+}
+
+// closeSinks closes all the sinks, even if closing one of them fails. The first error is returned.
+func closeSinks(ctx context.Context, sinks []Sink, pendingDays []time.Time, runErr error) error {
+	var firstErr error
+	for _, sink := range sinks {
+		if err := sink.Close(ctx, pendingDays, runErr); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: closing output %s: %v\n", sink.Name(), err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("output %s: %w", sink.Name(), err)
+			}
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+	"github.com/jmoiron/sqlx"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/alerting"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/usagemodel"
+)
+
+const (
+	// outputAlerts checks the usage against the configured thresholds.
+	outputAlerts = "alerts"
+
+	// alertMetricCHF is the total amount charged in CHF.
+	alertMetricCHF = "chf"
+
+	// alertResolveAfter is a bit longer than the interval of the collector's runs, so an alert keeps firing as long as
+	// every run sends it again.
+	alertResolveAfter = 25 * time.Hour
+)
+
+// alertMetrics maps the metrics thresholds can be configured for to their source query. The usage is compared in the
+// unit of the query, e.g. GBDay for storage.
+var alertMetrics = map[string]string{
+	"storage":  sourceQueryStorage,
+	"traffic":  sourceQueryTrafficOut,
+	"requests": sourceQueryRequests,
+}
+
+// alertMetricNames returns the names of all metrics thresholds can be configured for.
+func alertMetricNames() []string {
+	return []string{"storage", "traffic", "requests", alertMetricCHF}
+}
+
+// alertMetric returns the metric of the given source query, or "" if there is none.
+func alertMetric(query string) string {
+	for metric, q := range alertMetrics {
+		if q == query {
+			return metric
+		}
+	}
+	return ""
+}
+
+/*
+alertSink compares the usage of every tenant against the configured thresholds, per collected day and for the month up
+to and including the collected day. The usage of the previous days of the month is read from the database. Exceeded
+thresholds are logged and sent to an Alertmanager, and the usage is exported as metrics.
+*/
+type alertSink struct {
+	thresholds      []alerting.Threshold
+	databaseURL     string
+	alertmanagerURL string
+	catalog         *catalog
+	rounding        *roundingPolicies
+	fallback        *db.Product
+	client          *http.Client
+
+	rdb *sqlx.DB
+}
+
+func newAlertSink(cfg *config, fallback *db.Product) *alertSink {
+	return &alertSink{
+		thresholds:      cfg.alertThresholds,
+		databaseURL:     cfg.databaseURL,
+		alertmanagerURL: cfg.alertmanagerURL,
+		catalog:         cfg.catalog,
+		rounding:        cfg.rounding,
+		fallback:        fallback,
+		client:          http.DefaultClient,
+	}
+}
+
+func (s *alertSink) Name() string {
+	return outputAlerts
+}
+
+// Open connects to the database if there are monthly thresholds.
+func (s *alertSink) Open(_ context.Context) error {
+	if !s.hasThresholds(alerting.PeriodMonth) {
+		return nil
+	}
+	rdb, err := openDatabase(s.databaseURL)
+	if err != nil {
+		return err
+	}
+	s.rdb = rdb
+	return nil
+}
+
+func (s *alertSink) hasThresholds(period string) bool {
+	for _, threshold := range s.thresholds {
+		if threshold.Period == period {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *alertSink) Write(ctx context.Context, date time.Time, accumulated map[AccumulateKey]uint64) error {
+	records, err := buildUsageRecords(accumulated, s.catalog, s.rounding, s.fallback)
+	if err != nil {
+		return err
+	}
+	day, err := usageOfRecords(records)
+	if err != nil {
+		return err
+	}
+	alerts := alerting.Evaluate(s.thresholds, alerting.PeriodDay, day)
+	s.observe(alerting.PeriodDay, day)
+
+	if s.rdb != nil {
+		month, err := s.monthUsage(ctx, date)
+		if err != nil {
+			return err
+		}
+		for tenant, metrics := range day {
+			for metric, value := range metrics {
+				month.Add(tenant, metric, value)
+			}
+		}
+		alerts = append(alerts, alerting.Evaluate(s.thresholds, alerting.PeriodMonth, month)...)
+		s.observe(alerting.PeriodMonth, month)
+	}
+
+	for _, alert := range alerts {
+		fmt.Fprintf(os.Stderr, "ALERT: %s: %s\n", date.Format("2006-01-02"), alert)
+	}
+	if s.alertmanagerURL == "" {
+		return nil
+	}
+	return alerting.Send(ctx, s.client, s.alertmanagerURL, alerts, alertResolveAfter)
+}
+
+// monthUsage returns the usage stored in the database from the start of the month up to, but excluding, date.
+func (s *alertSink) monthUsage(ctx context.Context, date time.Time) (alerting.Usage, error) {
+	tx, err := s.rdb.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	monthStart := time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, date.Location())
+	usage, err := usagemodel.Get(ctx, tx, monthStart, date, s.catalog.queryNames())
+	if err != nil {
+		return nil, err
+	}
+	r, err := buildReport(usage, monthStart, date)
+	if err != nil {
+		return nil, err
+	}
+	month := alerting.Usage{}
+	for _, tenant := range r.Tenants {
+		for _, line := range tenant.Lines {
+			query, _, _ := strings.Cut(line.Query, ":")
+			if metric := alertMetric(query); metric != "" {
+				month.Add(tenant.Tenant, metric, line.Quantity)
+			}
+			month.Add(tenant.Tenant, alertMetricCHF, line.Total)
+		}
+	}
+	return month, nil
+}
+
+// observe updates the usage and limit metrics of all tenants with a threshold.
+func (s *alertSink) observe(period string, usage alerting.Usage) {
+	for _, threshold := range s.thresholds {
+		if threshold.Period != period {
+			continue
+		}
+		limit, _ := new(big.Rat).SetString(threshold.Limit.String())
+		for tenant, metrics := range usage {
+			if threshold.Tenant != alerting.AllTenants && threshold.Tenant != tenant {
+				continue
+			}
+			value := new(big.Rat)
+			if v, ok := metrics[threshold.Metric]; ok {
+				value = v
+			}
+			valueF, _ := value.Float64()
+			limitF, _ := limit.Float64()
+			tenantUsageGauge.WithLabelValues(tenant, threshold.Metric, period).Set(valueF)
+			tenantUsageLimitGauge.WithLabelValues(tenant, threshold.Metric, period).Set(limitF)
+		}
+	}
+}
+
+func (s *alertSink) Close(_ context.Context, _ []time.Time, _ error) error {
+	if s.rdb != nil {
+		return s.rdb.Close()
+	}
+	return nil
+}
+
+// usageOfRecords sums up the quantities and totals of the records per tenant.
+func usageOfRecords(records []usageRecord) (alerting.Usage, error) {
+	usage := alerting.Usage{}
+	for _, record := range records {
+		quantity, ok := new(big.Rat).SetString(record.Quantity.String())
+		if !ok {
+			return nil, fmt.Errorf("invalid quantity %q of %s", record.Quantity, record.Source)
+		}
+		total, ok := new(big.Rat).SetString(record.Total.String())
+		if !ok {
+			return nil, fmt.Errorf("invalid total %q of %s", record.Total, record.Source)
+		}
+		if metric := alertMetric(record.Query); metric != "" {
+			usage.Add(record.Tenant, metric, quantity)
+		}
+		usage.Add(record.Tenant, alertMetricCHF, total)
+	}
+	return usage, nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsageOfRecords(t *testing.T) {
+	usage, err := usageOfRecords([]usageRecord{
+		{Query: sourceQueryStorage, Tenant: "inity", Quantity: "1.5", Total: "0.0045"},
+		{Query: sourceQueryStorage, Tenant: "inity", Quantity: "0.5", Total: "0.0015"},
+		{Query: sourceQueryRequests, Tenant: "inity", Quantity: "3", Total: "0.015"},
+		{Query: sourceQueryTrafficOut, Tenant: "vshn", Quantity: "10", Total: "0.2"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, big.NewRat(2, 1), usage["inity"]["storage"])
+	assert.Equal(t, big.NewRat(3, 1), usage["inity"]["requests"])
+	assert.Equal(t, big.NewRat(21, 1000), usage["inity"][alertMetricCHF])
+	assert.Equal(t, big.NewRat(10, 1), usage["vshn"]["traffic"])
+	assert.NotContains(t, usage["vshn"], "storage")
+
+	_, err = usageOfRecords([]usageRecord{{Query: sourceQueryStorage, Tenant: "inity", Quantity: "x", Total: "0"}})
+	assert.Error(t, err)
+}
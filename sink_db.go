@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+	"github.com/jmoiron/sqlx"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/categoriesmodel"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/correctionsmodel"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/datetimesmodel"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/discountsmodel"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/factsmodel"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/heldfactsmodel"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/productsmodel"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/queriesmodel"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/runsmodel"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/tenantsmodel"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/units"
+)
+
+// dbSink writes facts to the appuio-cloud-reporting database. Every run is recorded in the collector_runs table.
+type dbSink struct {
+	databaseURL string
+	catalog     *catalog
+	rounding    *roundingPolicies
+	anomaly     anomalyConfig
+	// fallbackProduct is the source of the product of sources without product, none if empty
+	fallbackProduct string
+
+	rdb      *sqlx.DB
+	run      *run
+	fallback *db.Product
+	// written are the values written by the last call to Write, see Written
+	written map[AccumulateKey]uint64
+}
+
+func newDBSink(cfg *config) *dbSink {
+	return &dbSink{
+		databaseURL: cfg.databaseURL,
+		catalog:     cfg.catalog,
+		rounding:    cfg.rounding,
+		anomaly:     cfg.anomaly,
+
+		fallbackProduct: cfg.fallbackProduct,
+	}
+}
+
+func (s *dbSink) Name() string {
+	return outputDB
+}
+
+// Open connects to the database, ensures the catalog and starts the run.
+func (s *dbSink) Open(ctx context.Context) error {
+	rdb, err := openDatabase(s.databaseURL)
+	if err != nil {
+		return err
+	}
+	s.rdb = rdb
+
+	// initialize DB
+	tx, err := rdb.BeginTxx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer func(tx *sqlx.Tx) {
+		err := tx.Rollback()
+		if err != nil && !errors.Is(err, sql.ErrTxDone) {
+			fmt.Fprintf(os.Stderr, "rollback failed: %v", err)
+		}
+	}(tx)
+	err = initDb(ctx, tx, s.catalog)
+	if err != nil {
+		return err
+	}
+	if s.fallbackProduct != "" {
+		s.fallback, err = productsmodel.GetBySource(ctx, tx, s.fallbackProduct)
+		if err != nil {
+			return err
+		}
+		if s.fallback == nil {
+			return fmt.Errorf("fallback product %q not found", s.fallbackProduct)
+		}
+		if err := checkFallbackUnit(s.fallback, s.catalog.queries); err != nil {
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	r := newRun()
+	err = r.start(ctx, rdb)
+	if err != nil {
+		return err
+	}
+	s.run = r
+	fmt.Printf("starting run %s\n", r.Id)
+	return nil
+}
+
+// MissingDays returns the days before date without facts of this collector, see findMissingDays.
+func (s *dbSink) MissingDays(ctx context.Context, date time.Time, days int) ([]time.Time, error) {
+	return findMissingDays(ctx, s.rdb, s.catalog, date, days)
+}
+
+// Close records the outcome of the run and prints its summary, also if the run failed, as the facts written until then
+// have been committed.
+func (s *dbSink) Close(ctx context.Context, pendingDays []time.Time, runErr error) error {
+	if s.rdb == nil {
+		return nil
+	}
+	defer s.rdb.Close()
+	if s.run == nil {
+		return nil
+	}
+
+	for _, day := range pendingDays {
+		s.run.pendingDays = append(s.run.pendingDays, day.Format("2006-01-02"))
+	}
+	err := s.run.finish(ctx, s.rdb, runErr)
+	s.run.printSummary(os.Stdout)
+	return err
+}
+
+func initDb(ctx context.Context, tx *sqlx.Tx, c *catalog) error {
+	for _, product := range c.products {
+		_, err := productsmodel.Ensure(ctx, tx, product)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, discount := range c.discounts {
+		_, err := discountsmodel.Ensure(ctx, tx, discount)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, query := range c.queries {
+		_, err := queriesmodel.Ensure(ctx, tx, query)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := runsmodel.EnsureTables(ctx, tx)
+	if err != nil {
+		return err
+	}
+	err = correctionsmodel.EnsureTable(ctx, tx)
+	if err != nil {
+		return err
+	}
+	return heldfactsmodel.EnsureTable(ctx, tx)
+}
+
+// Write writes the accumulated values as facts to the database.
+// Nothing is written unless all the sources are covered by the catalog, see checkCoverage.
+// If anomaly detection is enabled, the facts are compared to their baseline first, see checkAnomaly.
+func (s *dbSink) Write(ctx context.Context, date time.Time, accumulated map[AccumulateKey]uint64) error {
+	if err := s.checkCoverage(ctx, accumulated); err != nil {
+		return err
+	}
+
+	var baselines map[string][]float64
+	if s.anomaly.factor > 0 {
+		var err error
+		baselines, err = loadBaselines(ctx, s.rdb, s.catalog, date, s.anomaly.baselineDays, s.anomaly.hourly)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.written = make(map[AccumulateKey]uint64, len(accumulated))
+	for source, value := range accumulated {
+		if value == 0 {
+			continue
+		}
+		if baselines != nil {
+			skip, err := s.checkAnomaly(ctx, source, value, baselines[baselineKey(source.String(), source.Start, s.anomaly.hourly)])
+			if err != nil {
+				return err
+			}
+			if skip {
+				continue
+			}
+		}
+		err := s.writeFact(ctx, source, value)
+		if err != nil {
+			return err
+		}
+		s.written[source] = value
+	}
+
+	s.run.days = append(s.run.days, date.Format("2006-01-02"))
+	return nil
+}
+
+// Written returns the values written by the last call to Write, without the facts held back.
+func (s *dbSink) Written() map[AccumulateKey]uint64 {
+	return s.written
+}
+
+// writeFact writes the value as fact to the database in a transaction of its own, see writeFactTx.
+func (s *dbSink) writeFact(ctx context.Context, source AccumulateKey, value uint64) error {
+	// start new transaction for actual work
+	tx, err := s.rdb.BeginTxx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	written, err := s.writeFactTx(ctx, tx, source, value)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.run.countFact(written)
+	return nil
+}
+
+// writeFactTx writes the value as fact to the database within tx.
+// Every fact created or updated is linked to the run, and every fact whose quantity changed is recorded as a correction.
+// The written fact must be counted by the run once tx has been committed, see run.countFact.
+func (s *dbSink) writeFactTx(ctx context.Context, tx *sqlx.Tx, source AccumulateKey, value uint64) (writtenFact, error) {
+	r := s.run
+	fmt.Printf("syncing %s\n", source)
+
+	tenant, err := tenantsmodel.Ensure(ctx, tx, &db.Tenant{Source: source.Tenant})
+	if err != nil {
+		return writtenFact{}, err
+	}
+
+	category, err := categoriesmodel.Ensure(ctx, tx, &db.Category{Source: source.Zone + ":" + source.Namespace})
+	if err != nil {
+		return writtenFact{}, err
+	}
+
+	dateTime := datetimesmodel.New(source.Start)
+	dateTime, err = datetimesmodel.Ensure(ctx, tx, dateTime)
+	if err != nil {
+		return writtenFact{}, err
+	}
+
+	c, err := s.resolveSource(ctx, tx, source)
+	if err != nil {
+		return writtenFact{}, err
+	}
+	if c.product == s.fallback && s.fallback != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: No product found for %s, using fallback product %q\n", source, s.fallback.Source)
+	}
+	quantity, err := factQuantity(source, value, c, s.rounding)
+	if err != nil {
+		return writtenFact{}, err
+	}
+	storageFact, err := factsmodel.New(dateTime, c.query, tenant, category, c.product, c.discount, quantity)
+	if err != nil {
+		return writtenFact{}, fmt.Errorf("cannot write %s: %w", source, err)
+	}
+	previous, err := factsmodel.GetByFact(ctx, tx, storageFact)
+	if err != nil {
+		return writtenFact{}, err
+	}
+	fact, err := factsmodel.Ensure(ctx, tx, storageFact)
+	if err != nil {
+		return writtenFact{}, err
+	}
+	written := writtenFact{previous: previous, fact: storageFact}
+	if !factChanged(previous, storageFact) {
+		return written, nil
+	}
+	err = runsmodel.LinkFact(ctx, tx, fact.Id, r.Id)
+	if err != nil {
+		return writtenFact{}, err
+	}
+	if previous == nil {
+		return written, nil
+	}
+	written.correction, err = correctionsmodel.Create(tx, &correctionsmodel.Correction{
+		FactId:      fact.Id,
+		RunId:       r.Id,
+		Source:      source.String(),
+		Timestamp:   source.Start,
+		OldQuantity: previous.Quantity,
+		NewQuantity: storageFact.Quantity,
+	})
+	if err != nil {
+		return writtenFact{}, err
+	}
+	return written, nil
+}
+
+// factQuantity returns the quantity of the fact for the raw value, converted to the unit of the query and rounded with
+// the policy of the product of the coverage.
+func factQuantity(source AccumulateKey, value uint64, c sourceCoverage, rounding *roundingPolicies) (float64, error) {
+	if missing := c.missing(); len(missing) > 0 {
+		return 0, &coverageError{Sources: []uncoveredSource{{Source: source.String(), Missing: missing}}}
+	}
+	converted, err := units.Default.Convert(value, sourceUnits[source.Query], c.query.Unit)
+	if err != nil {
+		return 0, fmt.Errorf("cannot convert %s: %w", source, err)
+	}
+	// Quantities stay exact until rounded, the conversion to float64 is only done for storing the fact.
+	quantity, _ := rounding.forProduct(c.product.Source).Round(converted).Float64()
+	return quantity, nil
+}
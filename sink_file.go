@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+)
+
+// fileSink writes usage records as CSV or JSON Lines to a file or stdout.
+type fileSink struct {
+	format   string
+	path     string
+	catalog  *catalog
+	rounding *roundingPolicies
+	fallback *db.Product
+
+	w io.Writer
+	// f is the temporary file the records are written to, it replaces the file at path on Close
+	f       *os.File
+	records []usageRecord
+	days    int
+}
+
+// newFileSink returns a sink writing in the given format (outputCSV or outputJSONL) to path. If path is empty or
+// "-", the records are written to stdout.
+func newFileSink(format, path string, catalog *catalog, rounding *roundingPolicies, fallback *db.Product) *fileSink {
+	return &fileSink{format: format, path: path, catalog: catalog, rounding: rounding, fallback: fallback}
+}
+
+func (s *fileSink) Name() string {
+	return s.format
+}
+
+// Open creates a temporary file next to the output file, so the output file is only replaced once the run succeeded.
+func (s *fileSink) Open(_ context.Context) error {
+	if s.path == "" || s.path == "-" {
+		s.w = os.Stdout
+		return nil
+	}
+	f, err := os.CreateTemp(filepath.Dir(s.path), "."+filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("cannot create output file: %w", err)
+	}
+	// CreateTemp only grants access to the owner, the output file is readable by everyone like before
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return fmt.Errorf("cannot create output file: %w", err)
+	}
+	s.f = f
+	s.w = f
+	return nil
+}
+
+// Write buffers the records, they are written on Close so a CSV file has a single header.
+func (s *fileSink) Write(_ context.Context, _ time.Time, accumulated map[AccumulateKey]uint64) error {
+	records, err := buildUsageRecords(accumulated, s.catalog, s.rounding, s.fallback)
+	if err != nil {
+		return err
+	}
+	s.records = append(s.records, records...)
+	s.days++
+	return nil
+}
+
+// Close writes the records. The output file is only replaced if the run succeeded and at least one day has been
+// written, otherwise the previous output is kept.
+func (s *fileSink) Close(_ context.Context, _ []time.Time, runErr error) error {
+	if s.f == nil {
+		if runErr != nil {
+			return nil
+		}
+		return writeUsageRecords(s.w, s.format, s.records)
+	}
+
+	keep := runErr == nil && s.days > 0
+	var err error
+	if keep {
+		err = writeUsageRecords(s.w, s.format, s.records)
+	}
+	if closeErr := s.f.Close(); err == nil {
+		err = closeErr
+	}
+	if keep && err == nil {
+		return os.Rename(s.f.Name(), s.path)
+	}
+	if removeErr := os.Remove(s.f.Name()); err == nil {
+		err = removeErr
+	}
+	return err
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSink(t *testing.T) {
+	ctx := context.Background()
+	date := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	accumulated := map[AccumulateKey]uint64{
+		{Query: sourceQueryStorage, Zone: "cloudscale", Tenant: "inity", Namespace: "testnamespace", Start: date}: 1000000000,
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "usage.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte("previous\n"), 0o600))
+	run := func(days int, runErr error) string {
+		sink := newFileSink(outputJSONL, path, newCatalog([]string{defaultSourceZone}), &roundingPolicies{}, nil)
+		require.NoError(t, sink.Open(ctx))
+		for i := 0; i < days; i++ {
+			require.NoError(t, sink.Write(ctx, date, accumulated))
+		}
+		require.NoError(t, sink.Close(ctx, nil, runErr))
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return string(content)
+	}
+
+	assert.Equal(t, "previous\n", run(1, errors.New("failed")), "a failed run keeps the previous output")
+	assert.Equal(t, "previous\n", run(0, nil), "a run without days keeps the previous output")
+	output := run(1, nil)
+	assert.Equal(t, 1, strings.Count(output, "\n"))
+	assert.Contains(t, output, `"tenant":"inity"`)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "the temporary files are removed")
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutputError(t *testing.T) {
+	date := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	cause := errors.New("connection refused")
+	err := &outputError{Output: outputWebhook, Date: date, Written: []string{outputDB, outputCSV}, Err: cause}
+	assert.EqualError(t, err, "output webhook: connection refused (day 2022-10-01 has been written to db, csv already)")
+	assert.ErrorIs(t, err, cause)
+	assert.EqualError(t, &outputError{Output: outputDB, Date: date, Err: cause}, "output db: connection refused")
+}
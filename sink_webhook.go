@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/appuio/appuio-cloud-reporting/pkg/db"
+)
+
+// webhookSink posts the usage records of every day as JSON to an HTTP endpoint.
+type webhookSink struct {
+	url      string
+	catalog  *catalog
+	rounding *roundingPolicies
+	fallback *db.Product
+	client   *http.Client
+}
+
+// webhookPayload is the body posted to the webhook for every day.
+type webhookPayload struct {
+	Date    string        `json:"date"`
+	Records []usageRecord `json:"records"`
+}
+
+func newWebhookSink(url string, catalog *catalog, rounding *roundingPolicies, fallback *db.Product) *webhookSink {
+	return &webhookSink{url: url, catalog: catalog, rounding: rounding, fallback: fallback, client: http.DefaultClient}
+}
+
+func (s *webhookSink) Name() string {
+	return outputWebhook
+}
+
+func (s *webhookSink) Open(_ context.Context) error {
+	return nil
+}
+
+func (s *webhookSink) Write(ctx context.Context, date time.Time, accumulated map[AccumulateKey]uint64) error {
+	records, err := buildUsageRecords(accumulated, s.catalog, s.rounding, s.fallback)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(webhookPayload{Date: date.Format("2006-01-02"), Records: records})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("webhook returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+func (s *webhookSink) Close(_ context.Context, _ []time.Time, _ error) error {
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookSink(t *testing.T) {
+	location, err := time.LoadLocation("Europe/Zurich")
+	require.NoError(t, err, "could not load location Europe/Zurich")
+	date := time.Date(2022, 10, 1, 0, 0, 0, 0, location)
+	key := AccumulateKey{Query: sourceQueryStorage, Zone: "cloudscale", Tenant: "inity", Namespace: "testnamespace", Start: date}
+
+	var received []webhookPayload
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var payload webhookPayload
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		received = append(received, payload)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	rounding, err := parseRoundingPolicies("")
+	require.NoError(t, err)
+	sink := newWebhookSink(server.URL, newCatalog([]string{defaultSourceZone}), rounding, nil)
+	ctx := context.Background()
+	require.NoError(t, sink.Open(ctx))
+	require.NoError(t, sink.Write(ctx, date, map[AccumulateKey]uint64{key: 1500000000}))
+
+	require.Len(t, received, 1)
+	assert.Equal(t, "2022-10-01", received[0].Date)
+	require.Len(t, received[0].Records, 1)
+	assert.Equal(t, key.String(), received[0].Records[0].Source)
+	assert.Equal(t, "1.5", received[0].Records[0].Quantity.String())
+
+	status = http.StatusInternalServerError
+	assert.Error(t, sink.Write(ctx, date, map[AccumulateKey]uint64{key: 1}))
+	assert.NoError(t, sink.Close(ctx, nil, nil))
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vshn/cloudscale-metrics-collector/pkg/tokenmatcher"
+	"github.com/vshn/cloudscale-metrics-collector/pkg/units"
+)
+
+// validateUnits ensures that the raw values of every query and product in the catalog can be converted into the
+// query's or product's unit. All the problems found are reported at once.
+func validateUnits(c *catalog) error {
+	var problems []string
+	check := func(kind, source, unit string) {
+		query := tokenmatcher.NewTokenizedSource(source).Tokens[0]
+		rawUnit, ok := sourceUnits[query]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s %q: no raw unit known for query %q", kind, source, query))
+			return
+		}
+		if _, err := units.Default.Factor(rawUnit, unit); err != nil {
+			problems = append(problems, fmt.Sprintf("%s %q: %v", kind, source, err))
+		}
+	}
+
+	for _, query := range c.queries {
+		check("query", query.Name, query.Unit)
+	}
+	for _, product := range c.products {
+		check("product", product.Source, product.Unit)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("unsupported units in catalog:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/cloudscale-ch/cloudscale-go-sdk/v2"
+	cloudscalev1 "github.com/vshn/provider-cloudscale/apis/cloudscale/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// reportingTables are the tables of the appuio-cloud-reporting schema the collector needs.
+var reportingTables = []string{"categories", "date_times", "discounts", "facts", "products", "queries", "tenants"}
+
+// check is the outcome of a single validation step. A nil err means the check passed.
+type check struct {
+	name string
+	err  error
+}
+
+/*
+validateCommand checks the configuration of the sync command without collecting anything: the configuration and
+catalog, the connection to the database and its schema, the authentication of every cloudscale account, and the access
+to Namespaces and Buckets of every Kubernetes cluster. It prints a checklist and fails if any of the checks failed.
+*/
+func validateCommand(ctx context.Context, _ []string) error {
+	checks := runChecks(ctx)
+	failed := printChecks(os.Stdout, checks)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+	return nil
+}
+
+func runChecks(ctx context.Context) []check {
+	cfg, err := loadConfig()
+	checks := []check{{name: "configuration", err: err}}
+	if err != nil {
+		return checks
+	}
+	checks = append(checks,
+		check{name: "catalog units", err: validateUnits(cfg.catalog)},
+		check{name: "rounding policies", err: cfg.rounding.checkProducts(cfg.catalog.products, cfg.fallbackProduct)},
+	)
+
+	if cfg.databaseURL != "" {
+		checks = append(checks, checkDatabase(ctx, cfg.databaseURL)...)
+	}
+
+	for _, a := range newAccounts(cfg.accounts) {
+		checks = append(checks, check{name: fmt.Sprintf("cloudscale account %s", a.Name), err: checkCloudscale(ctx, a.client)})
+	}
+
+	clusters, err := newClusters(cfg)
+	if err != nil {
+		return append(checks, check{name: "kubernetes clients", err: err})
+	}
+	for _, c := range clusters {
+		checks = append(checks,
+			check{name: fmt.Sprintf("cluster %s: namespaces", c.name), err: c.client.List(ctx, &corev1.NamespaceList{}, client.Limit(1))},
+			check{name: fmt.Sprintf("cluster %s: buckets", c.name), err: c.client.List(ctx, &cloudscalev1.BucketList{}, client.Limit(1))},
+		)
+	}
+	return checks
+}
+
+// checkDatabase connects to the database and verifies that the appuio-cloud-reporting tables exist.
+func checkDatabase(ctx context.Context, databaseURL string) []check {
+	rdb, err := openDatabase(databaseURL)
+	if err == nil {
+		err = rdb.PingContext(ctx)
+	}
+	if err != nil {
+		return []check{{name: "database connection", err: err}}
+	}
+	defer rdb.Close()
+
+	var tables []string
+	err = rdb.SelectContext(ctx, &tables,
+		`SELECT table_name FROM information_schema.tables WHERE table_schema = current_schema()`)
+	if err == nil {
+		err = missingTables(tables)
+	}
+	return []check{
+		{name: "database connection"},
+		{name: "appuio-cloud-reporting tables", err: err},
+	}
+}
+
+// missingTables returns an error listing the reporting tables not in tables.
+func missingTables(tables []string) error {
+	var missing []string
+	for _, table := range reportingTables {
+		if !containsString(tables, table) {
+			missing = append(missing, table)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("missing tables %v, have the appuio-cloud-reporting migrations been run?", missing)
+	}
+	return nil
+}
+
+// checkCloudscale fetches the bucket metrics of yesterday, which needs a valid token with read access.
+func checkCloudscale(ctx context.Context, cloudscaleClient *cloudscale.Client) error {
+	yesterday := time.Now().AddDate(0, 0, -1)
+	_, err := cloudscaleClient.Metrics.GetBucketMetrics(ctx, &cloudscale.BucketMetricsRequest{Start: yesterday, End: yesterday})
+	return err
+}
+
+// printChecks writes the checklist to w and returns the number of failed checks.
+func printChecks(w io.Writer, checks []check) int {
+	failed := 0
+	for _, c := range checks {
+		if c.err != nil {
+			failed++
+			fmt.Fprintf(w, "[FAIL] %s: %v\n", c.name, c.err)
+			continue
+		}
+		fmt.Fprintf(w, "[PASS] %s\n", c.name)
+	}
+	return failed
+}
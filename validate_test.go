@@ -0,0 +1,25 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrintChecks(t *testing.T) {
+	var buf bytes.Buffer
+	failed := printChecks(&buf, []check{
+		{name: "configuration"},
+		{name: "cloudscale account default", err: errors.New("401 Unauthorized")},
+	})
+	assert.Equal(t, 1, failed)
+	assert.Equal(t, "[PASS] configuration\n[FAIL] cloudscale account default: 401 Unauthorized\n", buf.String())
+}
+
+func TestMissingTables(t *testing.T) {
+	assert.NoError(t, missingTables(append([]string{"collector_runs"}, reportingTables...)))
+	assert.EqualError(t, missingTables([]string{"facts", "queries", "tenants", "products"}),
+		"missing tables [categories date_times discounts], have the appuio-cloud-reporting migrations been run?")
+}